@@ -0,0 +1,869 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/backfill"
+	"github.com/edfward/readkey/digest"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/publish"
+	"github.com/edfward/readkey/util"
+	"github.com/edfward/readkey/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prefix of the versioned JSON API.
+const apiV1Prefix = "/api/v1"
+
+// Error codes of the versioned API, along with HTTP status codes.
+const (
+	errCodeInvalidRequest = "invalid_request"
+	errCodeUnauthorized   = "unauthorized"
+	errCodeForbidden      = "forbidden"
+	errCodeNotFound       = "not_found"
+	errCodeConflict       = "conflict"
+	errCodeInvalidFeed    = "invalid_feed"
+	errCodeUnavailable    = "unavailable"
+	errCodeStorage        = "storage_error"
+)
+
+// Successful responses of the versioned API are of format { data }.
+type apiResponse struct {
+	Data interface{} `json:"data"`
+}
+
+// Failed responses of the versioned API are of format { error: { code, message } }.
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	// Machine readable code such as "not_found".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Respond with data in the envelope of the versioned API.
+func respondData(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, apiResponse{data})
+}
+
+// Respond with an error in the envelope of the versioned API.
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, apiErrorResponse{apiError{code, message}})
+}
+
+// Respond to requests of the versioned API failing authentication.
+func respondAuthErrorV1(c *gin.Context, status int, reason string) {
+	if status == 401 {
+		respondError(c, 401, errCodeUnauthorized, reason)
+	} else {
+		respondError(c, status, errCodeStorage, reason)
+	}
+}
+
+// Respond 500 to a request of the versioned API failing due to a storage error, which is logged.
+func respondStorageErrorV1(c *gin.Context, err error) {
+	log.Printf("[e] Storage error handling %s %s: %v\n", c.Request.Method, c.Request.URL.Path, err)
+	respondError(c, 500, errCodeStorage, "storage error")
+}
+
+// Route of the versioned API, which the OpenAPI document is generated from.
+type apiRoute struct {
+	Method string
+	// Path relative to the API prefix, with gin style parameters such as ":id".
+	Path    string
+	Summary string
+	// Query parameters, by name to description.
+	Query map[string]string
+	// Sample of the JSON request body if any, whose type is described.
+	Request interface{}
+	// Status code and sample of the data in a successful response, nil for none.
+	Status   int
+	Response interface{}
+	// Status codes of the expected failures, besides 401 and 500 which any route may fail with.
+	Errors  []int
+	Handler gin.HandlerFunc
+}
+
+// Request bodies of the versioned API.
+type (
+	subscribeRequest struct {
+		URL string `json:"url"`
+	}
+	markReadRequest struct {
+		// Feed IDs of the items to mark, ignored if `all` is set.
+		ItemIDs []string `json:"itemIds"`
+		All     bool     `json:"all"`
+	}
+	createTokenRequest struct {
+		Name string `json:"name"`
+		// Username Fever API clients log in with, if the token is to be used with them.
+		Fever string `json:"fever,omitempty"`
+	}
+	publishRequest struct {
+		// One of "starred", "subscription" and "keyword".
+		View     string `json:"view"`
+		SourceID string `json:"sourceId,omitempty"`
+		Keyword  string `json:"keyword,omitempty"`
+		Title    string `json:"title,omitempty"`
+	}
+	reextractRequest struct {
+		// Feed source whose items are re-extracted, all sources if empty.
+		SourceID string `json:"sourceId,omitempty"`
+	}
+	createWebhookRequest struct {
+		URL string `json:"url"`
+		// Only post items of this subscription if set.
+		SourceID string `json:"sourceId,omitempty"`
+		// Only post items whose title or keywords contain it if set.
+		Keyword string `json:"keyword,omitempty"`
+	}
+)
+
+// Responses of the versioned API other than model types.
+type (
+	createdToken struct {
+		user.APIToken
+		// The token itself, only shown once.
+		Token string `json:"token"`
+	}
+	createdWebhook struct {
+		user.Webhook
+		// Secret payloads are signed with, only shown once.
+		Secret string `json:"secret"`
+	}
+	createdPublishedFeed struct {
+		user.PublishedFeed
+		// Secret URL the feed is served at, only shown once.
+		URL string `json:"url"`
+	}
+	queuedCount struct {
+		// Number of feed items queued.
+		Queued int `json:"queued"`
+	}
+	sentDigest struct {
+		// Number of items in the digest, none is sent if zero.
+		Count int `json:"count"`
+	}
+	unreadCount struct {
+		Count int64 `json:"count"`
+	}
+)
+
+// Routes of the versioned API. All but the OpenAPI document require authentication.
+var apiV1Routes = []apiRoute{
+	{
+		Method: "GET", Path: "/subscriptions", Summary: "List subscriptions",
+		Status: 200, Response: []subscriptionView{},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			subs, err := user.GetFeedSubscriptions(username)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			views, err := getSubscriptionViews(username, subs)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, views)
+		},
+	},
+	{
+		Method: "POST", Path: "/subscriptions", Summary: "Subscribe to the feed at a URL",
+		Request: subscribeRequest{},
+		Status:  201, Response: subscriptionView{},
+		Errors: []int{400, 409, 422},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			var req subscribeRequest
+			if err := c.BindJSON(&req); err != nil || strings.TrimSpace(req.URL) == "" {
+				respondError(c, 400, errCodeInvalidRequest, "missing url")
+				return
+			}
+			src, err := subscribe(username, req.URL)
+			if _, invalid := err.(invalidFeedError); invalid {
+				respondError(c, 422, errCodeInvalidFeed, err.Error())
+				return
+			} else if err == user.ErrExists {
+				respondError(c, 409, errCodeConflict, "already subscribed")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			view, err := getSubscriptionView(username, src.SourceID)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 201, view)
+		},
+	},
+	{
+		Method: "GET", Path: "/subscriptions/:id", Summary: "Get a subscription",
+		Status: 200, Response: subscriptionView{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			view, err := getSubscriptionView(username, util.Escape(c.Param("id")))
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "subscription not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, view)
+		},
+	},
+	{
+		Method: "PATCH", Path: "/subscriptions/:id", Summary: "Change the title or settings of a subscription",
+		Request: subscriptionPatch{},
+		Status:  200, Response: subscriptionView{},
+		Errors: []int{400, 404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			var patch subscriptionPatch
+			if err := c.BindJSON(&patch); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			} else if err := patch.validate(); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			var enabling bool
+			_, err := user.UpdateFeedSubscription(username, srcID, func(sub *user.Subscription) {
+				enabling = patch.enablesSummaries(*sub)
+				patch.apply(sub)
+			})
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "subscription not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			if enabling {
+				queueSummaries(srcID)
+			}
+			view, err := getSubscriptionView(username, srcID)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, view)
+		},
+	},
+	{
+		Method: "DELETE", Path: "/subscriptions/:id", Summary: "Unsubscribe",
+		Status: 204,
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			if err := user.RemoveFeedSubscription(username, util.Escape(c.Param("id"))); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "subscription not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "GET", Path: "/subscriptions/:id/items", Summary: "List feed item entries of a subscription",
+		Query: map[string]string{
+			"state": `"unread" (default) for unread items only, or "all" to include the latest read ones`,
+			"lang":  `language tag the items are in, e.g. "en", which also matches "en-us"; items of unknown language are left out`,
+		},
+		Status: 200, Response: []feed.ItemEntry{},
+		Errors: []int{400, 404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			state := c.Query("state")
+			if state != "" && state != "unread" && state != "all" {
+				respondError(c, 400, errCodeInvalidRequest, "state must be one of 'unread' or 'all'")
+				return
+			}
+			sub, err := user.GetFeedSubscription(username, srcID)
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "subscription not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			feedIDs, err := user.GetUnreadFeedIds(username, srcID)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			if state == "all" {
+				recentIDs, err := feed.GetRecentItemIdsFromSource(srcID)
+				if err != nil {
+					respondStorageErrorV1(c, err)
+					return
+				}
+				feedIDs = appendMissing(feedIDs, recentIDs)
+			}
+			entries, err := feed.GetItemEntriesFromSource(srcID, feedIDs)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			entries = filterLang(entries, c.Query("lang"))
+			if !sub.Settings.Summaries {
+				hideSummaries(entries)
+			}
+			if entries == nil {
+				entries = []feed.ItemEntry{}
+			}
+			respondData(c, 200, entries)
+		},
+	},
+	{
+		Method: "POST", Path: "/subscriptions/:id/read", Summary: "Mark items of a subscription as read",
+		Request: markReadRequest{},
+		Status:  204,
+		Errors:  []int{400, 404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			var req markReadRequest
+			if err := c.BindJSON(&req); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			if !requireSubscription(c, username, srcID) {
+				return
+			}
+			var err error
+			if req.All {
+				err = user.RemoveAllUnreadFeedItem(username, srcID)
+			} else {
+				feedIDs := make([]string, 0, len(req.ItemIDs))
+				for _, id := range req.ItemIDs {
+					feedIDs = append(feedIDs, util.Escape(id))
+				}
+				err = user.RemoveUnreadFeedItemIDs(username, srcID, feedIDs)
+			}
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "GET", Path: "/subscriptions/:id/unread-count", Summary: "Count unread items of a subscription",
+		Status: 200, Response: unreadCount{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			if !requireSubscription(c, username, srcID) {
+				return
+			}
+			cnt, err := user.GetUnreadFeedCount(username, srcID)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, unreadCount{cnt})
+		},
+	},
+	{
+		Method: "GET", Path: "/subscriptions/:id/status", Summary: "Get the fetching health of a subscription's feed source",
+		Status: 200, Response: feed.Health{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			if !requireSubscription(c, username, srcID) {
+				return
+			}
+			// Nothing is recorded if the source is yet to be fetched.
+			health, err := feed.GetSourceHealth(srcID)
+			if err != nil && err != feed.ErrNotFound {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, health)
+		},
+	},
+	{
+		Method: "GET", Path: "/items/:id", Summary: "Get the content of a feed item",
+		Status: 200, Response: feed.Item{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			item, err := feed.GetItem(util.Escape(c.Param("id")))
+			if err == feed.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "feed item not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, item)
+		},
+	},
+	{
+		Method: "GET", Path: "/tokens", Summary: "List personal access tokens",
+		Status: 200, Response: []user.APIToken{},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			tokens, err := user.GetAPITokens(username)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, tokens)
+		},
+	},
+	{
+		Method: "POST", Path: "/tokens", Summary: "Create a personal access token, only from a login session",
+		Request: createTokenRequest{},
+		Status:  201, Response: createdToken{},
+		Errors: []int{400, 403},
+		Handler: func(c *gin.Context) {
+			if _, viaToken := c.Get("tokenAuth"); viaToken {
+				respondError(c, 403, errCodeForbidden, "tokens can only be created from a login session")
+				return
+			}
+			username := c.MustGet("userid").(string)
+			var req createTokenRequest
+			if err := c.BindJSON(&req); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			token, t, err := user.CreateAPIToken(username, strings.TrimSpace(req.Name), strings.TrimSpace(req.Fever))
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 201, createdToken{t, token})
+		},
+	},
+	{
+		Method: "DELETE", Path: "/tokens/:id", Summary: "Revoke a personal access token",
+		Status: 204,
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			if err := user.RevokeAPIToken(username, c.Param("id")); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "token not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "GET", Path: "/webhooks", Summary: "List webhooks",
+		Status: 200, Response: []user.Webhook{},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			webhooks, err := user.GetWebhooks(username)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, webhooks)
+		},
+	},
+	{
+		Method: "POST", Path: "/webhooks", Summary: "Register a webhook new feed items are posted to",
+		Request: createWebhookRequest{},
+		Status:  201, Response: createdWebhook{},
+		Errors: []int{400, 404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			var req createWebhookRequest
+			if err := c.BindJSON(&req); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				respondError(c, 400, errCodeInvalidRequest, "url must be an absolute HTTP(S) URL")
+				return
+			}
+			w := user.Webhook{URL: req.URL, Keyword: strings.TrimSpace(req.Keyword)}
+			if req.SourceID != "" {
+				w.SourceID = util.Escape(req.SourceID)
+				if !requireSubscription(c, username, w.SourceID) {
+					return
+				}
+			}
+			secret, w, err := user.CreateWebhook(username, w)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 201, createdWebhook{w, secret})
+		},
+	},
+	{
+		Method: "DELETE", Path: "/webhooks/:id", Summary: "Remove a webhook",
+		Status: 204,
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			if err := user.RemoveWebhook(username, c.Param("id")); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "webhook not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "GET", Path: "/webhooks/:id/deliveries", Summary: "List the latest deliveries to a webhook",
+		Status: 200, Response: []user.WebhookDelivery{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			webhookID := c.Param("id")
+			if _, _, err := user.GetWebhook(username, webhookID); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "webhook not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			deliveries, err := user.GetWebhookDeliveries(username, webhookID)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, deliveries)
+		},
+	},
+	{
+		Method: "POST", Path: "/webhooks/:id/test", Summary: "Post a ping to a webhook and report the outcome",
+		Status: 200, Response: user.WebhookDelivery{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			d, err := webhook.Test(username, c.Param("id"))
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "webhook not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, d)
+		},
+	},
+	{
+		Method: "GET", Path: "/published", Summary: "List published feeds",
+		Status: 200, Response: []user.PublishedFeed{},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			feeds, err := user.GetPublishedFeeds(username)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, feeds)
+		},
+	},
+	{
+		Method: "POST", Path: "/published", Summary: "Publish the starred items, a subscription or a keyword view as a feed at a secret URL",
+		Request: publishRequest{},
+		Status:  201, Response: createdPublishedFeed{},
+		Errors: []int{400, 404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			var req publishRequest
+			if err := c.BindJSON(&req); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			pf := user.PublishedFeed{View: req.View, Title: strings.TrimSpace(req.Title)}
+			switch req.View {
+			case user.PublishStarred:
+				if pf.Title == "" {
+					pf.Title = "Starred items"
+				}
+			case user.PublishSubscription:
+				pf.SourceID = util.Escape(req.SourceID)
+				sub, err := user.GetFeedSubscription(username, pf.SourceID)
+				if err == user.ErrNotFound {
+					respondError(c, 404, errCodeNotFound, "subscription not found")
+					return
+				} else if err != nil {
+					respondStorageErrorV1(c, err)
+					return
+				}
+				if pf.Title == "" {
+					pf.Title = sub.Title
+					if sub.CustomTitle != "" {
+						pf.Title = sub.CustomTitle
+					}
+				}
+			case user.PublishKeyword:
+				if pf.Keyword = strings.TrimSpace(req.Keyword); pf.Keyword == "" {
+					respondError(c, 400, errCodeInvalidRequest, "missing keyword")
+					return
+				}
+				if pf.Title == "" {
+					pf.Title = "Items about " + pf.Keyword
+				}
+			default:
+				respondError(c, 400, errCodeInvalidRequest, "view must be one of 'starred', 'subscription' or 'keyword'")
+				return
+			}
+			token, pf, err := user.CreatePublishedFeed(username, pf)
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 201, createdPublishedFeed{pf, publish.URL(c, token)})
+		},
+	},
+	{
+		Method: "DELETE", Path: "/published/:id", Summary: "Unpublish a feed",
+		Status: 204,
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			if err := user.RemovePublishedFeed(username, c.Param("id")); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "published feed not found")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "GET", Path: "/digest", Summary: "Get the email digest preferences",
+		Status: 200, Response: user.DigestPrefs{},
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			prefs, err := user.GetDigestPrefs(username)
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "no digest preferences")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, prefs)
+		},
+	},
+	{
+		Method: "PUT", Path: "/digest", Summary: "Set the email digest preferences",
+		Request: user.DigestPrefs{},
+		Status:  200, Response: user.DigestPrefs{},
+		Errors: []int{400},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			var prefs user.DigestPrefs
+			if err := c.BindJSON(&prefs); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			if err := validateDigestPrefs(&prefs); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			if err := user.SetDigestPrefs(username, prefs); err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, prefs)
+		},
+	},
+	{
+		Method: "DELETE", Path: "/digest", Summary: "Stop sending email digests",
+		Status: 204,
+		Errors: []int{404},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			if err := user.RemoveDigestPrefs(username); err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "no digest preferences")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			c.Writer.WriteHeader(204)
+		},
+	},
+	{
+		Method: "POST", Path: "/digest/send", Summary: "Email a digest of all unread items right away",
+		Status: 200, Response: sentDigest{},
+		Errors: []int{404, 502, 503},
+		Handler: func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			prefs, err := user.GetDigestPrefs(username)
+			if err == user.ErrNotFound {
+				respondError(c, 404, errCodeNotFound, "no digest preferences")
+				return
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			n, err := digest.Send(username, prefs, time.Time{})
+			if err == digest.ErrNotConfigured {
+				respondError(c, 503, errCodeUnavailable, err.Error())
+				return
+			} else if _, failed := err.(digest.MailError); failed {
+				log.Printf("[e] Failed to send digest to %s: %v\n", username, err)
+				respondError(c, 502, errCodeUnavailable, err.Error())
+				return
+			} else if _, sent := err.(digest.MarkReadError); sent {
+				log.Printf("[e] Failed to mark digest items of %s read: %v\n", username, err)
+			} else if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			respondData(c, 200, sentDigest{n})
+		},
+	},
+	{
+		Method: "POST", Path: "/admin/keywords/reextract", Summary: "Queue re-extracting the keywords of the items of a feed source or of all sources, for administrators",
+		Request: reextractRequest{},
+		Status:  202, Response: queuedCount{},
+		Errors: []int{400, 403, 404},
+		Handler: func(c *gin.Context) {
+			if !isAdmin(c.MustGet("userid").(string)) {
+				respondError(c, 403, errCodeForbidden, "administrators only")
+				return
+			}
+			var req reextractRequest
+			if err := c.BindJSON(&req); err != nil {
+				respondError(c, 400, errCodeInvalidRequest, err.Error())
+				return
+			}
+			srcs, err := feed.GetListeningSources()
+			if err != nil {
+				respondStorageErrorV1(c, err)
+				return
+			}
+			var srcIDs []string
+			for _, src := range srcs {
+				if req.SourceID == "" || src.SourceID == util.Escape(req.SourceID) {
+					srcIDs = append(srcIDs, src.SourceID)
+				}
+			}
+			if req.SourceID != "" && len(srcIDs) == 0 {
+				respondError(c, 404, errCodeNotFound, "feed source not found")
+				return
+			}
+			var queued int
+			for _, srcID := range srcIDs {
+				n, err := backfill.EnqueueSource(srcID)
+				queued += n
+				if err != nil {
+					respondStorageErrorV1(c, err)
+					return
+				}
+			}
+			log.Printf("[i] Queued re-extracting keywords of %d feed item(s)\n", queued)
+			respondData(c, 202, queuedCount{queued})
+		},
+	},
+}
+
+// Check and normalize digest preferences set by a user.
+func validateDigestPrefs(prefs *user.DigestPrefs) error {
+	addr, err := mail.ParseAddress(prefs.Email)
+	if err != nil {
+		return errors.New("email must be a valid address")
+	}
+	prefs.Email = addr.Address
+	if prefs.Frequency != user.DigestDaily && prefs.Frequency != user.DigestWeekly {
+		return errors.New("frequency must be one of 'daily' or 'weekly'")
+	}
+	if prefs.Hour < 0 || prefs.Hour > 23 {
+		return errors.New("hour must be between 0 and 23")
+	}
+	if prefs.Weekday < 0 || prefs.Weekday > 6 {
+		return errors.New("weekday must be between 0 (Sunday) and 6")
+	}
+	return nil
+}
+
+// Respond 404 unless the user subscribes to the feed source, return whether subscribed.
+func requireSubscription(c *gin.Context, username, srcID string) bool {
+	if _, err := user.GetFeedSubscription(username, srcID); err == user.ErrNotFound {
+		respondError(c, 404, errCodeNotFound, "subscription not found")
+		return false
+	} else if err != nil {
+		respondStorageErrorV1(c, err)
+		return false
+	}
+	return true
+}
+
+// Register the versioned API together with its OpenAPI document at "/api/v1/openapi.json".
+func mountAPIv1(r *gin.Engine) {
+	doc := buildOpenAPI(apiV1Prefix, apiV1Routes)
+	r.GET(apiV1Prefix+"/openapi.json", func(c *gin.Context) {
+		c.JSON(200, doc)
+	})
+
+	v1 := r.Group(apiV1Prefix)
+	v1.Use(tokenAuthRequired(respondAuthErrorV1))
+	for _, route := range apiV1Routes {
+		v1.Handle(route.Method, route.Path, route.Handler)
+	}
+}
+
+// Append the IDs not in the list yet.
+func appendMissing(ids, more []string) []string {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, id := range more {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Clear the summaries of entries listed for a subscription with summaries off. Entries are shared by
+// the subscribers of a source, and summarized while any of them has summaries on.
+func hideSummaries(entries []feed.ItemEntry) {
+	for i := range entries {
+		entries[i].Summary = ""
+	}
+}
+
+// Keep the entries in the language of tag `lang`, all of them if it is empty.
+func filterLang(entries []feed.ItemEntry, lang string) []feed.ItemEntry {
+	if lang == "" {
+		return entries
+	}
+	var res []feed.ItemEntry
+	for _, e := range entries {
+		if e.MatchesLang(lang) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
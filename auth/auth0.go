@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// Authentication through Auth0, whose login page is served by the web client and ID tokens are
+// signed with the client secret.
+type auth0Provider struct {
+	flow *oauthFlow
+}
+
+func newAuth0Provider() *auth0Provider {
+	domain := os.Getenv("AUTH0_DOMAIN")
+	conf := &oauth2.Config{
+		ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
+		ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("AUTH0_CALLBACK_URL"),
+		Scopes:       []string{"openid"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://" + domain + "/authorize",
+			TokenURL: "https://" + domain + "/oauth/token",
+		},
+	}
+	return &auth0Provider{
+		flow: &oauthFlow{conf: conf},
+	}
+}
+
+func (p *auth0Provider) Mount(r *gin.Engine) {
+	// Login endpoint, whose login buttons should lead to "login/start".
+	r.StaticFile("login", "./web/login.html")
+	r.GET("login/start", p.flow.start)
+
+	// Auto0 callbacks. From https://auth0.com/docs/server-platforms/golang#go-web-app-tutorial
+	r.GET("callback", p.callback)
+}
+
+func (p *auth0Provider) callback(c *gin.Context) {
+	// Validating and exchanging the Code that we got from Auth0 for a token.
+	token, _, ok := p.flow.finish(c)
+	if !ok {
+		return
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	// From Auth0's Documentation -> Backend/API -> Go.
+	parsedToken, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		decoded, _ := base64.URLEncoding.DecodeString(p.flow.conf.ClientSecret)
+		return decoded, nil
+	})
+	if err != nil || !parsedToken.Valid {
+		c.String(500, "parsing id token failed")
+		return
+	}
+	// The user ID looks like 'github|123456'.
+	sub, _ := parsedToken.Claims["sub"].(string)
+	if sub == "" {
+		c.String(500, "id token without subject")
+		return
+	}
+	if err := Login(c, sub); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.Redirect(302, "/")
+}
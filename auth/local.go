@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/edfward/readkey/model/user"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Usernames of local accounts.
+var usernameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+const minPasswordLength = 8
+
+// Session key of the token the login form must post back, against login CSRF.
+const sessionCSRFKey = "loginCSRF"
+
+var localLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>ReadKey Login</title></head>
+<body>
+  {{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+  <form method="post" action="/login">
+    <input type="hidden" name="csrf" value="{{.CSRF}}">
+    <input name="username" placeholder="Username" autofocus>
+    <input name="password" type="password" placeholder="Password">
+    <button type="submit">Log in</button>
+    {{if .Signup}}<button type="submit" formaction="/signup">Sign up</button>{{end}}
+  </form>
+</body>
+</html>
+`))
+
+// Authentication with username and password of accounts kept in the backend storage.
+type localProvider struct {
+	signup bool
+	// Compared against when the account doesn't exist, so that timing doesn't reveal it.
+	dummyHash []byte
+}
+
+func newLocalProvider() *localProvider {
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return &localProvider{
+		signup:    os.Getenv("LOCAL_AUTH_SIGNUP") == "1",
+		dummyHash: dummyHash,
+	}
+}
+
+func (p *localProvider) Mount(r *gin.Engine) {
+	r.GET("login", func(c *gin.Context) {
+		p.renderLogin(c, 200, "")
+	})
+	r.POST("login", p.checkCSRF, p.login)
+	if p.signup {
+		r.POST("signup", p.checkCSRF, p.createAccount)
+	}
+}
+
+func (p *localProvider) renderLogin(c *gin.Context, status int, errMsg string) {
+	// Reuse the session's token, so that several open login pages all work.
+	session := sessions.Default(c)
+	token, _ := session.Get(sessionCSRFKey).(string)
+	if token == "" {
+		var err error
+		if token, err = randomString(); err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		session.Set(sessionCSRFKey, token)
+		if err := session.Save(); err != nil {
+			c.String(500, err.Error())
+			return
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.WriteHeader(status)
+	localLoginPage.Execute(c.Writer, struct {
+		Error  string
+		Signup bool
+		CSRF   string
+	}{errMsg, p.signup, token})
+}
+
+// Reject posts of the login form from other sites, by the Origin or Referer header if sent, and by
+// the token of the session the form was rendered for.
+func (p *localProvider) checkCSRF(c *gin.Context) {
+	source := c.GetHeader("Origin")
+	if source == "" {
+		source = c.GetHeader("Referer")
+	}
+	if source != "" {
+		// Behind a proxy, the host the browser sees is forwarded.
+		u, err := url.Parse(source)
+		if err != nil || !strings.EqualFold(u.Host, c.Request.Host) && !strings.EqualFold(u.Host, c.GetHeader("X-Forwarded-Host")) {
+			c.AbortWithStatus(403)
+			return
+		}
+	}
+	token, _ := sessions.Default(c).Get(sessionCSRFKey).(string)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.PostForm("csrf"))) != 1 {
+		c.Abort()
+		p.renderLogin(c, 403, "The login page expired, please try again.")
+		return
+	}
+}
+
+func (p *localProvider) login(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+
+	hash, err := user.GetAccountPasswordHash(username)
+	if err != nil && err != user.ErrNotFound {
+		log.Printf("[e] Failed to get account: %v\n", err)
+		p.renderLogin(c, 500, "Logging in is unavailable, please try again later.")
+		return
+	} else if err == user.ErrNotFound {
+		bcrypt.CompareHashAndPassword(p.dummyHash, []byte(password))
+		p.renderLogin(c, 401, "Wrong username or password.")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		p.renderLogin(c, 401, "Wrong username or password.")
+		return
+	}
+	if err := Login(c, "local|"+username); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.Redirect(302, "/")
+}
+
+func (p *localProvider) createAccount(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+	if !usernameRe.MatchString(username) {
+		p.renderLogin(c, 400, "Usernames are up to 64 letters, digits, '_', '.' or '-'.")
+		return
+	}
+	if len(password) < minPasswordLength {
+		p.renderLogin(c, 400, "Passwords need at least 8 characters.")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if err := user.CreateAccount(username, string(hash)); err == user.ErrExists {
+		p.renderLogin(c, 409, "The username is taken.")
+		return
+	} else if err != nil {
+		log.Printf("[e] Failed to create account: %v\n", err)
+		p.renderLogin(c, 500, "Signing up is unavailable, please try again later.")
+		return
+	}
+	if err := Login(c, "local|"+username); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.Redirect(302, "/")
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// Session keys of a pending OAuth login.
+const (
+	sessionStateKey    = "oauthState"
+	sessionVerifierKey = "oauthVerifier"
+	sessionNonceKey    = "oauthNonce"
+)
+
+var loginErrorPage = template.Must(template.New("loginError").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>ReadKey Login</title></head>
+<body>
+  <p>{{.}}</p>
+  <p><a href="/login">Back to login</a></p>
+</body>
+</html>
+`))
+
+// The authorization code flow shared by OAuth based providers. Login starts at the server, which
+// keeps the state parameter (against CSRF) and the PKCE code verifier (against code interception)
+// in the session until the callback.
+type oauthFlow struct {
+	conf *oauth2.Config
+	// Whether to send a nonce to be included in the ID token.
+	withNonce bool
+}
+
+// Redirect to the authorization endpoint of the provider.
+func (f *oauthFlow) start(c *gin.Context) {
+	state, err := randomString()
+	if err != nil {
+		loginFailed(c, 500, "Couldn't start the login, please try again.")
+		return
+	}
+	verifier, err := randomString()
+	if err != nil {
+		loginFailed(c, 500, "Couldn't start the login, please try again.")
+		return
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+
+	session := sessions.Default(c)
+	session.Set(sessionStateKey, state)
+	session.Set(sessionVerifierKey, verifier)
+	if f.withNonce {
+		nonce, err := randomString()
+		if err != nil {
+			loginFailed(c, 500, "Couldn't start the login, please try again.")
+			return
+		}
+		session.Set(sessionNonceKey, nonce)
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	if err := session.Save(); err != nil {
+		loginFailed(c, 500, "Couldn't start the login, please try again.")
+		return
+	}
+	c.Redirect(302, f.conf.AuthCodeURL(state, opts...))
+}
+
+// Validate the callback and exchange the code for a token, also return the nonce sent if any.
+// Upon failure a response is already written and false is returned.
+func (f *oauthFlow) finish(c *gin.Context) (*oauth2.Token, string, bool) {
+	session := sessions.Default(c)
+	state, _ := session.Get(sessionStateKey).(string)
+	verifier, _ := session.Get(sessionVerifierKey).(string)
+	nonce, _ := session.Get(sessionNonceKey).(string)
+	// Single use.
+	session.Delete(sessionStateKey)
+	session.Delete(sessionVerifierKey)
+	session.Delete(sessionNonceKey)
+	session.Save()
+
+	if errCode := c.Query("error"); errCode != "" {
+		providerError(c, errCode, c.Query("error_description"))
+		return nil, "", false
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		loginFailed(c, 400, "The login has expired or didn't start here, please log in again.")
+		return nil, "", false
+	}
+	code := c.Query("code")
+	if code == "" {
+		loginFailed(c, 400, "The identity provider didn't return an authorization code.")
+		return nil, "", false
+	}
+
+	token, err := f.conf.Exchange(oauth2.NoContext, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		log.Printf("[e] Failed to exchange authorization code: %v\n", err)
+		loginFailed(c, 502, "Couldn't complete the login with the identity provider, please try again.")
+		return nil, "", false
+	}
+	return token, nonce, true
+}
+
+// Respond to an error returned by the identity provider to the callback.
+// Ref: https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+func providerError(c *gin.Context, errCode, description string) {
+	log.Printf("[i] Identity provider returned error %q: %s\n", errCode, description)
+	switch errCode {
+	case "access_denied":
+		loginFailed(c, 403, "The login was cancelled or access was denied.")
+	case "login_required", "consent_required", "interaction_required":
+		loginFailed(c, 401, "The identity provider needs you to log in again.")
+	case "server_error", "temporarily_unavailable":
+		loginFailed(c, 503, "The identity provider is unavailable at the moment, please try again later.")
+	default:
+		if description == "" {
+			description = errCode
+		}
+		loginFailed(c, 400, "The login failed: "+description)
+	}
+}
+
+func loginFailed(c *gin.Context, status int, msg string) {
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.WriteHeader(status)
+	loginErrorPage.Execute(c.Writer, msg)
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// Authentication through a generic OpenID Connect provider, configured from its discovery document
+// and verifying RS256 signed ID tokens against its published keys.
+type oidcProvider struct {
+	flow    *oauthFlow
+	issuer  string
+	jwksURL string
+	client  *http.Client
+	// Signing keys of the provider by key ID, refreshed when meeting an unknown key ID.
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+	keysLock      *sync.Mutex
+}
+
+// Subset of the OpenID Connect discovery document.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func newOIDCProvider() (*oidcProvider, error) {
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil, errors.New("OIDC_ISSUER is not set")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var doc oidcDiscovery
+	if err := getJSON(client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, errors.New("fetching OpenID Connect discovery document failed: " + err.Error())
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer mismatch in discovery document: %q", doc.Issuer)
+	}
+
+	conf := &oauth2.Config{
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_CALLBACK_URL"),
+		Scopes:       []string{"openid"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}
+	return &oidcProvider{
+		flow:     &oauthFlow{conf: conf, withNonce: true},
+		issuer:   doc.Issuer,
+		jwksURL:  doc.JWKSURI,
+		client:   client,
+		keys:     make(map[string]*rsa.PublicKey),
+		keysLock: &sync.Mutex{},
+	}, nil
+}
+
+func (p *oidcProvider) Mount(r *gin.Engine) {
+	// Login endpoint, simply redirecting to the provider.
+	r.GET("login", func(c *gin.Context) {
+		c.Redirect(302, "/login/start")
+	})
+	r.GET("login/start", p.flow.start)
+	r.GET("callback", p.callback)
+}
+
+func (p *oidcProvider) callback(c *gin.Context) {
+	token, nonce, ok := p.flow.finish(c)
+	if !ok {
+		return
+	}
+	idToken, _ := token.Extra("id_token").(string)
+	sub, err := p.verify(idToken, nonce)
+	if err != nil {
+		log.Printf("[e] Failed to verify ID token: %v\n", err)
+		loginFailed(c, 401, "The identity provider returned an invalid ID token.")
+		return
+	}
+	if err := Login(c, "oidc|"+sub); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.Redirect(302, "/")
+}
+
+// Verify the ID token and return its subject.
+func (p *oidcProvider) verify(idToken, nonce string) (string, error) {
+	parsedToken, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != "RS256" {
+			return nil, errors.New("unexpected signing method " + token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return "", err
+	} else if !parsedToken.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	claims := parsedToken.Claims
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return "", errors.New("issuer mismatch")
+	}
+	if !audienceContains(claims["aud"], p.flow.conf.ClientID) {
+		return "", errors.New("audience mismatch")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return "", errors.New("missing expiry")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" ||
+		subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return "", errors.New("nonce mismatch")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", errors.New("missing subject")
+	}
+	return sub, nil
+}
+
+// The "aud" claim is either a string or a list of strings.
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the signing key of the given ID, refetching the key set at most once a minute if unknown.
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.keysLock.Lock()
+	defer p.keysLock.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < time.Minute {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	p.keysFetchedAt = time.Now()
+	if err := getJSON(p.client, p.jwksURL, &jwks); err != nil {
+		return nil, errors.New("fetching signing keys failed: " + err.Error())
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key " + kid)
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/rand"
+	// Registers SHA-384/512 for verifying tokens signed with them.
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionUserKey is the session key of the authenticated user ID.
+const SessionUserKey = "userid"
+
+// Provider is the standard interface of an authentication backend.
+type Provider interface {
+	// Mount registers the login endpoints of the provider, such as the login page and callbacks.
+	Mount(r *gin.Engine)
+}
+
+// RequestAuthenticator is implemented by providers authenticating every request by themselves
+// rather than through the login session.
+type RequestAuthenticator interface {
+	// Authenticate returns the user ID of the request, return false if not authenticated.
+	Authenticate(c *gin.Context) (string, bool)
+}
+
+// New builds the provider of the given name, configured by environment variables:
+//   - "auth0": AUTH0_DOMAIN, AUTH0_CLIENT_ID, AUTH0_CLIENT_SECRET, AUTH0_CALLBACK_URL.
+//   - "oidc": OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_CALLBACK_URL.
+//   - "local": LOCAL_AUTH_SIGNUP set to "1" to allow creating accounts.
+//   - "proxy": AUTH_PROXY_HEADER (default "X-Forwarded-User"), AUTH_PROXY_TRUSTED as comma separated
+//     CIDRs of the proxies (default loopback only).
+func New(name string) (Provider, error) {
+	switch name {
+	case "auth0":
+		return newAuth0Provider(), nil
+	case "oidc":
+		return newOIDCProvider()
+	case "local":
+		return newLocalProvider(), nil
+	case "proxy":
+		return newProxyProvider()
+	}
+	return nil, errors.New("unknown authentication provider: " + name)
+}
+
+// Login establishes the session of an authenticated user.
+func Login(c *gin.Context, userID string) error {
+	session := sessions.Default(c)
+	session.Set(SessionUserKey, userID)
+	return session.Save()
+}
+
+// Generates a random URL-safe string, e.g. for OAuth state and nonce.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication delegated to a trusted reverse proxy, which passes the user in a request header.
+// The header is only honored for requests coming from the trusted proxies.
+type proxyProvider struct {
+	header  string
+	trusted []*net.IPNet
+}
+
+func newProxyProvider() (*proxyProvider, error) {
+	header := os.Getenv("AUTH_PROXY_HEADER")
+	if header == "" {
+		header = "X-Forwarded-User"
+	}
+	trustedCIDRs := os.Getenv("AUTH_PROXY_TRUSTED")
+	if trustedCIDRs == "" {
+		trustedCIDRs = "127.0.0.0/8,::1/128"
+	}
+
+	p := &proxyProvider{header: header}
+	for _, cidr := range strings.Split(trustedCIDRs, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, errors.New("invalid AUTH_PROXY_TRUSTED: " + err.Error())
+		}
+		p.trusted = append(p.trusted, ipNet)
+	}
+	return p, nil
+}
+
+func (p *proxyProvider) Mount(r *gin.Engine) {
+	// Reached only when the proxy let an unauthenticated request through.
+	r.GET("login", func(c *gin.Context) {
+		c.String(401, "Not authenticated by the reverse proxy.")
+	})
+}
+
+func (p *proxyProvider) Authenticate(c *gin.Context) (string, bool) {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return "", false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.isTrusted(ip) {
+		return "", false
+	}
+	if username := strings.TrimSpace(c.Request.Header.Get(p.header)); username != "" {
+		return "proxy|" + username, true
+	}
+	return "", false
+}
+
+func (p *proxyProvider) isTrusted(ip net.IP) bool {
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionName is the name of the session cookie.
+const SessionName = "readkey-session"
+
+// Sessions last for 30 days.
+const sessionMaxAge = 86400 * 30
+
+// NewSessionStore builds the session store of the given kind: "cookie" keeps sessions in signed and
+// encrypted cookies, while "redis" keeps them server-side so that they are revoked for good on logout.
+// Keys are read from SESSION_KEYS, whitespace separated entries of "<auth key>[:<encryption key>]" in
+// base64. The first entry is used for new sessions and all are accepted, so keys can be rotated by
+// prepending a new entry. Auth keys need at least 32 bytes, encryption keys 16, 24 or 32 bytes.
+func NewSessionStore(kind, redisServer string, secure bool) (sessions.Store, error) {
+	keyPairs, err := sessionKeyPairs(os.Getenv("SESSION_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var store sessions.Store
+	switch kind {
+	case "cookie":
+		store = sessions.NewCookieStore(keyPairs...)
+	case "redis":
+		const maxIdle = 3
+		if store, err = sessions.NewRedisStore(maxIdle, "tcp", redisServer, "", keyPairs...); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown session store: " + kind)
+	}
+	store.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   sessionMaxAge,
+		Secure:   secure,
+		HttpOnly: true,
+	})
+	return store, nil
+}
+
+func sessionKeyPairs(config string) ([][]byte, error) {
+	var keyPairs [][]byte
+	for _, entry := range strings.Fields(config) {
+		parts := strings.SplitN(entry, ":", 2)
+		authKey, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil || len(authKey) < 32 {
+			return nil, errors.New("invalid SESSION_KEYS: auth keys must be base64 of at least 32 bytes")
+		}
+		var encKey []byte
+		if len(parts) == 2 {
+			encKey, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil || (len(encKey) != 16 && len(encKey) != 24 && len(encKey) != 32) {
+				return nil, errors.New("invalid SESSION_KEYS: encryption keys must be base64 of 16, 24 or 32 bytes")
+			}
+		}
+		keyPairs = append(keyPairs, authKey, encKey)
+	}
+	if len(keyPairs) > 0 {
+		return keyPairs, nil
+	}
+
+	// Sessions won't survive a restart, but that beats a guessable key.
+	log.Printf("[w] SESSION_KEYS is not set, using random session keys.\n")
+	authKey := make([]byte, 32)
+	encKey := make([]byte, 32)
+	if _, err := rand.Read(authKey); err != nil {
+		return nil, fmt.Errorf("generating session keys failed: %v", err)
+	}
+	if _, err := rand.Read(encKey); err != nil {
+		return nil, fmt.Errorf("generating session keys failed: %v", err)
+	}
+	return [][]byte{authKey, encKey}, nil
+}
+
+// Logout ends the session, which is also deleted server-side with the Redis session store.
+func Logout(c *gin.Context) error {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	return session.Save()
+}
+
+// SameSite is the middleware setting the SameSite attribute (e.g. "Lax") of the session cookie, which
+// the session library can't set by itself.
+func SameSite(mode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &sameSiteWriter{ResponseWriter: c.Writer, mode: mode}
+		c.Next()
+	}
+}
+
+// Response writer adding the SameSite attribute to the session cookie right before headers are written.
+type sameSiteWriter struct {
+	gin.ResponseWriter
+	mode string
+}
+
+func (w *sameSiteWriter) setSameSite() {
+	cookies := w.Header()["Set-Cookie"]
+	for i, cookie := range cookies {
+		if strings.HasPrefix(cookie, SessionName+"=") && !strings.Contains(strings.ToLower(cookie), "samesite=") {
+			cookies[i] = cookie + "; SameSite=" + w.mode
+		}
+	}
+}
+
+func (w *sameSiteWriter) WriteHeader(code int) {
+	w.setSameSite()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sameSiteWriter) WriteHeaderNow() {
+	w.setSameSite()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sameSiteWriter) Write(data []byte) (int, error) {
+	w.setSameSite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sameSiteWriter) WriteString(s string) (int, error) {
+	w.setSameSite()
+	return w.ResponseWriter.WriteString(s)
+}
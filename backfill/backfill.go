@@ -0,0 +1,234 @@
+// Package backfill extracts the keywords of stored feed items after the fact, e.g. of items stored
+// without keywords, or with locally extracted ones, while the keyword server was unavailable, or to
+// re-extract them all after the extractor is upgraded. Items go through a durable queue in Redis,
+// processed by a bounded number of workers which retry with backoff while the extractor is
+// unavailable.
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/edfward/readkey/keyword"
+	"github.com/edfward/readkey/langdetect"
+	"github.com/edfward/readkey/libstore"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/summary"
+	"github.com/edfward/readkey/util"
+
+	"github.com/kennygrant/sanitize"
+)
+
+// Attempts of an extraction while the extractor is unavailable, and the delay before the first
+// retry which quadruples after each one, i.e. retrying for about 5 hours.
+const (
+	maxAttempts = 5
+	retryDelay  = time.Minute
+)
+
+var (
+	queue     *libstore.Queue
+	kwFetcher keyword.Fetcher
+)
+
+// Setup must be called before other functions to configure the Redis store and the keyword fetcher.
+func Setup(store libstore.RedisStrore, fetcher keyword.Fetcher) {
+	queue = libstore.NewQueue(store, util.FormatKeywordQueueKey())
+	kwFetcher = fetcher
+}
+
+// Task of a queued job summarizing an item rather than extracting its keywords, queued for the stored
+// items of a source once a subscriber turns summaries on.
+const taskSummary = "summary"
+
+// Queued extraction of the keywords or summary of a feed item.
+type job struct {
+	// Empty to extract keywords.
+	Task     string `json:"task,omitempty"`
+	SourceID string `json:"sourceId"`
+	FeedID   string `json:"feedId"`
+	// Language of the item, empty if unknown.
+	Lang string `json:"lang,omitempty"`
+	// Whether to extract afresh rather than take cached keywords of the same content.
+	Refresh bool `json:"refresh,omitempty"`
+	Attempt int  `json:"attempt"`
+}
+
+// Enqueue queues the extraction of the keywords of a feed item, whose entry is stored.
+func Enqueue(srcID, feedID, lang string) error {
+	packet, _ := json.Marshal(job{SourceID: srcID, FeedID: feedID, Lang: lang, Attempt: 1})
+	return queue.Push(packet)
+}
+
+// EnqueueSource queues the extraction of the keywords of all stored items of a feed source afresh,
+// bypassing cached keywords, return their number.
+func EnqueueSource(srcID string) (int, error) {
+	feedIDs, err := feed.GetItemEntryIDs(srcID)
+	if err != nil {
+		return 0, err
+	}
+	for i, feedID := range feedIDs {
+		packet, _ := json.Marshal(job{SourceID: srcID, FeedID: feedID, Refresh: true, Attempt: 1})
+		if err := queue.Push(packet); err != nil {
+			return i, err
+		}
+	}
+	return len(feedIDs), nil
+}
+
+// EnqueueSummaries queues summarizing all stored items of a feed source which have no summary yet,
+// return their number.
+func EnqueueSummaries(srcID string) (int, error) {
+	feedIDs, err := feed.GetItemEntryIDs(srcID)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := feed.GetItemEntriesFromSource(srcID, feedIDs)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if entry.Summary != "" {
+			continue
+		}
+		packet, _ := json.Marshal(job{Task: taskSummary, SourceID: srcID, FeedID: entry.FeedID, Lang: entry.Lang, Attempt: 1})
+		if err := queue.Push(packet); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Start recovers extractions interrupted by the last stop, then processes queued ones with `workers`
+// goroutines in the background.
+func Start(workers int) {
+	if n, err := queue.Requeue(); err != nil {
+		log.Printf("[e] Failed to requeue interrupted keyword extractions: %v\n", err)
+	} else if n > 0 {
+		log.Printf("[i] Requeued %d interrupted keyword extractions\n", n)
+	}
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			if _, err := queue.PromoteDue(); err != nil {
+				log.Printf("[e] Failed to queue keyword extractions due for retry: %v\n", err)
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go work()
+	}
+}
+
+func work() {
+	for {
+		packet, err := queue.Pop(5 * time.Second)
+		if err != nil {
+			log.Printf("[e] Failed to take keyword extraction from queue: %v\n", err)
+			time.Sleep(retryDelay)
+			continue
+		} else if packet == nil {
+			continue
+		}
+		var j job
+		if err := json.Unmarshal(packet, &j); err != nil {
+			log.Printf("[e] Dropped malformed keyword extraction: %v\n", err)
+		} else if retry := process(j); retry != nil {
+			next, _ := json.Marshal(retry)
+			if err := queue.RetryAt(packet, next, time.Now().Add(backoff(j.Attempt))); err != nil {
+				log.Printf("[e] Failed to schedule retry of keyword extraction of %s: %v\n", j.FeedID, err)
+			}
+			continue
+		}
+		if err := queue.Ack(packet); err != nil {
+			log.Printf("[e] Failed to remove keyword extraction from queue: %v\n", err)
+		}
+	}
+}
+
+// Process a queued job, return the next attempt if it is to be retried.
+func process(j job) *job {
+	if j.Task == taskSummary {
+		return summarize(j)
+	}
+	return extract(j)
+}
+
+// Extract and store the keywords of a queued item, return the next attempt if it is to be retried.
+func extract(j job) *job {
+	item, err := feed.GetItem(j.FeedID)
+	if err == feed.ErrNotFound {
+		return nil
+	} else if err != nil {
+		log.Printf("[e] Failed to get feed item %s: %v\n", j.FeedID, err)
+		// Not counted as an attempt.
+		return &j
+	}
+
+	if j.Lang == "" {
+		j.Lang = langdetect.Detect(sanitize.HTML(item.Content))
+	}
+	// Timed out by the keyword client once sent, as in the feed handlers.
+	ctx := context.Background()
+	if j.Refresh {
+		ctx = keyword.NoCache(ctx)
+	}
+	keywords, err := kwFetcher.Fetch(ctx, &item.Content, j.Lang)
+	_, unavailable := err.(keyword.UnavailableError)
+	_, fellBack := err.(keyword.FallbackError)
+	if unavailable || fellBack {
+		// Keep those of the fallback extractor in the meantime, or for good once given up.
+		if fellBack {
+			if err := feed.SetItemEntryKeywords(j.SourceID, j.FeedID, keywords, j.Lang); err != nil && err != feed.ErrNotFound {
+				log.Printf("[e] Failed to store keywords of %s: %v\n", j.FeedID, err)
+			}
+		}
+		if j.Attempt >= maxAttempts {
+			log.Printf("[e] Gave up extracting keywords of %s: %v\n", j.FeedID, err)
+			return nil
+		}
+		j.Attempt++
+		return &j
+	} else if err != nil {
+		log.Printf("[e] Failed to extract keywords of %s: %v\n", j.FeedID, err)
+		return nil
+	}
+
+	if err := feed.SetItemEntryKeywords(j.SourceID, j.FeedID, keywords, j.Lang); err != nil && err != feed.ErrNotFound {
+		log.Printf("[e] Failed to store keywords of %s: %v\n", j.FeedID, err)
+		return &j
+	}
+	return nil
+}
+
+// Summarize a queued item and store its summary, return the job if it is to be retried.
+func summarize(j job) *job {
+	item, err := feed.GetItem(j.FeedID)
+	if err == feed.ErrNotFound {
+		return nil
+	} else if err != nil {
+		log.Printf("[e] Failed to get feed item %s: %v\n", j.FeedID, err)
+		return &j
+	}
+	text := sanitize.HTML(item.Content)
+	if j.Lang == "" {
+		j.Lang = langdetect.Detect(text)
+	}
+	if err := feed.SetItemEntrySummary(j.SourceID, j.FeedID, summary.Summarize(text, j.Lang)); err != nil && err != feed.ErrNotFound {
+		log.Printf("[e] Failed to store summary of %s: %v\n", j.FeedID, err)
+		return &j
+	}
+	return nil
+}
+
+// Delay before retrying after a failed attempt.
+func backoff(attempt int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 4
+	}
+	return delay
+}
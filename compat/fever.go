@@ -0,0 +1,259 @@
+package compat
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The only Fever group, holding all subscriptions as there are no folders.
+const feverGroupID = 1
+
+// Items are returned 50 at most per request, as defined by the Fever API.
+const feverMaxItems = 50
+
+// MountFever registers the Fever API at "/fever/". Clients log in with the Fever username of a
+// personal access token and the token as password (see user.CreateAPIToken).
+func MountFever(r *gin.Engine) {
+	r.GET("/fever/", fever)
+	r.POST("/fever/", fever)
+}
+
+func fever(c *gin.Context) {
+	res := gin.H{"api_version": 3, "auth": 0}
+	c.Request.ParseForm()
+	form := c.Request.Form
+	username, err := user.LookupFeverKey(form.Get("api_key"))
+	if err == user.ErrNotFound {
+		c.JSON(200, res)
+		return
+	} else if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	res["auth"] = 1
+	res["last_refreshed_on_time"] = time.Now().Unix()
+	has := func(param string) bool {
+		_, ok := form[param]
+		return ok
+	}
+
+	srcs, err := getSources(username)
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	if has("mark") {
+		if err := feverMark(username, srcs, form.Get("mark"), form.Get("as"), form.Get("id"), form.Get("before")); err != nil {
+			respondStorageError(c, err)
+			return
+		}
+	}
+
+	if has("groups") || has("feeds") {
+		feedIDs := make([]string, 0, len(srcs))
+		for _, src := range srcs {
+			feedIDs = append(feedIDs, strconv.FormatInt(src.Ref, 10))
+		}
+		res["feeds_groups"] = []gin.H{{"group_id": feverGroupID, "feed_ids": strings.Join(feedIDs, ",")}}
+	}
+	if has("groups") {
+		res["groups"] = []gin.H{{"id": feverGroupID, "title": "All"}}
+	}
+	if has("feeds") {
+		srcIDs := make([]string, 0, len(srcs))
+		for _, src := range srcs {
+			srcIDs = append(srcIDs, src.SourceID)
+		}
+		health, err := feed.GetSourcesHealth(srcIDs)
+		if err != nil {
+			respondStorageError(c, err)
+			return
+		}
+		feeds := make([]gin.H, 0, len(srcs))
+		for _, src := range srcs {
+			feeds = append(feeds, gin.H{
+				"id":                   src.Ref,
+				"favicon_id":           0,
+				"title":                src.title(),
+				"url":                  src.URL,
+				"site_url":             src.Link,
+				"is_spark":             0,
+				"last_updated_on_time": health[src.SourceID].LastSuccess,
+			})
+		}
+		res["feeds"] = feeds
+	}
+	if has("favicons") {
+		res["favicons"] = []gin.H{}
+	}
+	if has("links") {
+		res["links"] = []gin.H{}
+	}
+
+	if !has("items") && !has("unread_item_ids") && !has("saved_item_ids") {
+		c.JSON(200, res)
+		return
+	}
+	items, err := collectItems(username, srcs, streamFilter{})
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	if has("items") {
+		res["total_items"] = len(items)
+		selected := selectFeverItems(items, form.Get("since_id"), form.Get("max_id"), form.Get("with_ids"))
+		if res["items"], err = feverItems(selected); err != nil {
+			respondStorageError(c, err)
+			return
+		}
+	}
+	if has("unread_item_ids") || has("saved_item_ids") {
+		var unread, saved []string
+		for _, item := range items {
+			if item.Unread {
+				unread = append(unread, strconv.FormatInt(item.Ref, 10))
+			}
+			if item.Starred {
+				saved = append(saved, strconv.FormatInt(item.Ref, 10))
+			}
+		}
+		if has("unread_item_ids") {
+			res["unread_item_ids"] = strings.Join(unread, ",")
+		}
+		if has("saved_item_ids") {
+			res["saved_item_ids"] = strings.Join(saved, ",")
+		}
+	}
+	c.JSON(200, res)
+}
+
+// Mark an item as read, unread, saved or unsaved, or a feed or group as read up to `before`.
+func feverMark(username string, srcs []*streamSource, mark, as, id, before string) error {
+	ref, ok := feed.ParseRef(id)
+	if mark == "group" && id == "0" {
+		// Group 0 is the implicit group of all feeds.
+		ref, ok = feverGroupID, true
+	}
+	if !ok {
+		return nil
+	}
+
+	switch mark {
+	case "item":
+		edit := map[string]string{"read": "read", "unread": "unread", "saved": "star", "unsaved": "unstar"}[as]
+		items, err := resolveItems(srcs, []int64{ref})
+		if err != nil || edit == "" {
+			return err
+		}
+		for _, item := range items {
+			if err := editItem(username, item, edit); err != nil {
+				return err
+			}
+		}
+	case "feed", "group":
+		if as != "read" {
+			return nil
+		}
+		f := streamFilter{UnreadOnly: true}
+		if mark == "feed" {
+			for _, src := range srcs {
+				if src.Ref == ref {
+					f.SourceID = src.SourceID
+				}
+			}
+			if f.SourceID == "" {
+				return nil
+			}
+		} else if ref != feverGroupID {
+			return nil
+		}
+		items, err := collectItems(username, srcs, f)
+		if err != nil {
+			return err
+		}
+		if ts, err := strconv.ParseInt(before, 10, 64); err == nil && ts > 0 {
+			items = publishedUntil(items, time.Unix(ts, 0))
+		}
+		return markRead(username, items)
+	}
+	return nil
+}
+
+// Select items by the parameters of the Fever API: `withIDs` as comma separated item IDs, or
+// those after `sinceID` in ascending order, or those before `maxID` in descending order.
+func selectFeverItems(items []*streamItem, sinceID, maxID, withIDs string) []*streamItem {
+	byRef := make([]*streamItem, len(items))
+	copy(byRef, items)
+	sort.Slice(byRef, func(i, j int) bool { return byRef[i].Ref < byRef[j].Ref })
+
+	var res []*streamItem
+	switch {
+	case withIDs != "":
+		wanted := make(map[int64]bool)
+		for _, id := range strings.Split(withIDs, ",") {
+			if ref, ok := feed.ParseRef(strings.TrimSpace(id)); ok {
+				wanted[ref] = true
+			}
+		}
+		for _, item := range byRef {
+			if wanted[item.Ref] {
+				res = append(res, item)
+			}
+		}
+	case maxID != "":
+		max, _ := strconv.ParseInt(maxID, 10, 64)
+		for i := len(byRef) - 1; i >= 0; i-- {
+			if byRef[i].Ref < max {
+				res = append(res, byRef[i])
+			}
+		}
+	default:
+		since, _ := strconv.ParseInt(sinceID, 10, 64)
+		for _, item := range byRef {
+			if item.Ref > since {
+				res = append(res, item)
+			}
+		}
+	}
+	if len(res) > feverMaxItems {
+		res = res[:feverMaxItems]
+	}
+	return res
+}
+
+func feverItems(items []*streamItem) ([]gin.H, error) {
+	res := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		// Items missing content are still listed, as their entries exist.
+		fi, err := feed.GetItem(item.Entry.FeedID)
+		if err != nil && err != feed.ErrNotFound {
+			return nil, err
+		}
+		res = append(res, gin.H{
+			"id":              item.Ref,
+			"feed_id":         item.Source.Ref,
+			"title":           item.Entry.Title,
+			"author":          "",
+			"html":            fi.Content,
+			"url":             fi.Link,
+			"is_saved":        boolInt(item.Starred),
+			"is_read":         boolInt(!item.Unread),
+			"created_on_time": unixTime(item.Time),
+		})
+	}
+	return res, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
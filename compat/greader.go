@@ -0,0 +1,507 @@
+package compat
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stream IDs of the Google Reader API, with the user ID replaced by "-".
+const (
+	readingListStream = "user/-/state/com.google/reading-list"
+	readStream        = "user/-/state/com.google/read"
+	starredStream     = "user/-/state/com.google/starred"
+	keptUnreadStream  = "user/-/state/com.google/kept-unread"
+	feedStreamPrefix  = "feed/"
+	itemIDPrefix      = "tag:google.com,2005:reader/item/"
+)
+
+// MountGoogleReader registers the Google Reader API, as implemented by FreshRSS and Miniflux, at
+// "/accounts/ClientLogin" and "/reader/api/0". Clients log in with a personal access token as the
+// password, the username is ignored.
+func MountGoogleReader(r *gin.Engine) {
+	// Log in, return the token to send as "Authorization: GoogleLogin auth=<token>" in plain text.
+	r.POST("/accounts/ClientLogin", clientLogin)
+	r.GET("/accounts/ClientLogin", clientLogin)
+
+	api := r.Group("/reader/api/0")
+	api.Use(googleLoginRequired())
+	{
+		// Token to send as parameter "T" of modifying requests. Requests are authenticated by
+		// header rather than cookie so it isn't checked, but clients insist on fetching one.
+		api.GET("token", func(c *gin.Context) {
+			sum := sha256.Sum256([]byte(c.Request.Header.Get("Authorization")))
+			c.String(200, fmt.Sprintf("%x", sum)[:57])
+		})
+
+		api.GET("user-info", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			c.JSON(200, gin.H{
+				"userId":        username,
+				"userName":      username,
+				"userProfileId": username,
+				"userEmail":     "",
+			})
+		})
+
+		api.GET("subscription/list", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcs, err := getSourcesCached(c, username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			subs := make([]gin.H, 0, len(srcs))
+			for _, src := range srcs {
+				subs = append(subs, gin.H{
+					"id":         feedStreamID(src),
+					"title":      src.title(),
+					"categories": []string{},
+					"url":        src.URL,
+					"htmlUrl":    src.Link,
+					"iconUrl":    "",
+				})
+			}
+			c.JSON(200, gin.H{"subscriptions": subs})
+		})
+
+		// There are no folders or labels, only the starred state.
+		api.GET("tag/list", func(c *gin.Context) {
+			c.JSON(200, gin.H{"tags": []gin.H{{"id": starredStream}}})
+		})
+
+		api.GET("unread-count", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcs, err := getSourcesCached(c, username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			items, err := collectItems(username, srcs, streamFilter{UnreadOnly: true})
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			counts := make(map[*streamSource]int)
+			newest := make(map[*streamSource]time.Time)
+			var newestOverall time.Time
+			for _, item := range items {
+				counts[item.Source]++
+				if item.Time.After(newest[item.Source]) {
+					newest[item.Source] = item.Time
+				}
+				if item.Time.After(newestOverall) {
+					newestOverall = item.Time
+				}
+			}
+			res := []gin.H{{
+				"id":                      readingListStream,
+				"count":                   len(items),
+				"newestItemTimestampUsec": usec(newestOverall),
+			}}
+			for src, count := range counts {
+				res = append(res, gin.H{
+					"id":                      feedStreamID(src),
+					"count":                   count,
+					"newestItemTimestampUsec": usec(newest[src]),
+				})
+			}
+			c.JSON(200, gin.H{"max": len(items), "unreadcounts": res})
+		})
+
+		api.GET("stream/items/ids", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			items, continuation, err := selectStream(c, username, c.Query("s"))
+			if err == errUnknownStream {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			} else if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			refs := make([]gin.H, 0, len(items))
+			for _, item := range items {
+				refs = append(refs, gin.H{
+					"id":              strconv.FormatInt(item.Ref, 10),
+					"directStreamIds": []string{},
+					"timestampUsec":   usec(item.Time),
+				})
+			}
+			res := gin.H{"itemRefs": refs}
+			if continuation != "" {
+				res["continuation"] = continuation
+			}
+			c.JSON(200, res)
+		})
+
+		// Fetch the contents of items given as "i" parameters.
+		itemContents := func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			c.Request.ParseForm()
+			var refs []int64
+			for _, id := range c.Request.Form["i"] {
+				if ref, ok := parseItemID(id); ok {
+					refs = append(refs, ref)
+				}
+			}
+			srcs, err := getSourcesCached(c, username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			items, err := loadItems(username, srcs, refs)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			writeContents(c, readingListStream, items, "")
+		}
+		api.GET("stream/items/contents", itemContents)
+		api.POST("stream/items/contents", itemContents)
+
+		api.GET("stream/contents/*stream", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			// Off-by-one to ignore the first '/'.
+			streamID := c.Param("stream")[1:]
+			if streamID == "" {
+				streamID = c.Query("s")
+			}
+			items, continuation, err := selectStream(c, username, streamID)
+			if err == errUnknownStream {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			} else if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			writeContents(c, streamID, items, continuation)
+		})
+
+		// Add tag "a" to and remove tag "r" from items "i", where tags are the read and starred states.
+		api.POST("edit-tag", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			c.Request.ParseForm()
+			var refs []int64
+			for _, id := range c.Request.Form["i"] {
+				if ref, ok := parseItemID(id); ok {
+					refs = append(refs, ref)
+				}
+			}
+			// Clients may add or remove several tags at once, e.g. marking read and starring.
+			add, remove := make(map[string]bool), make(map[string]bool)
+			for _, tag := range c.Request.Form["a"] {
+				add[normalizeStreamID(tag)] = true
+			}
+			for _, tag := range c.Request.Form["r"] {
+				remove[normalizeStreamID(tag)] = true
+			}
+			var edits []string
+			switch {
+			case add[readStream]:
+				edits = append(edits, "read")
+			case remove[readStream] || add[keptUnreadStream]:
+				edits = append(edits, "unread")
+			}
+			switch {
+			case add[starredStream]:
+				edits = append(edits, "star")
+			case remove[starredStream]:
+				edits = append(edits, "unstar")
+			}
+
+			srcs, err := getSourcesCached(c, username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			items, err := resolveItems(srcs, refs)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			for _, item := range items {
+				if err := editItem(username, item, edits...); err != nil {
+					respondStorageError(c, err)
+					return
+				}
+			}
+			c.String(200, "OK")
+		})
+
+		// Mark the items of stream "s" as read, only those published up to "ts" (in microseconds) if given.
+		api.POST("mark-all-as-read", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcs, err := getSourcesCached(c, username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			f, ok := parseStream(srcs, c.PostForm("s"))
+			if !ok {
+				c.JSON(400, gin.H{"error": errUnknownStream.Error()})
+				return
+			}
+			f.UnreadOnly = true
+			items, err := collectItems(username, srcs, f)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			if ts, err := strconv.ParseInt(c.PostForm("ts"), 10, 64); err == nil && ts > 0 {
+				items = publishedUntil(items, time.Unix(0, ts*int64(time.Microsecond)))
+			}
+			if err := markRead(username, items); err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			c.String(200, "OK")
+		})
+	}
+}
+
+// ClientLogin of the Google Reader API, where the password is a personal access token.
+func clientLogin(c *gin.Context) {
+	token := c.PostForm("Passwd")
+	if token == "" {
+		token = c.Query("Passwd")
+	}
+	if _, err := user.LookupAPIToken(token); err == user.ErrNotFound {
+		c.String(401, "Error=BadAuthentication\n")
+		return
+	} else if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	if c.Query("output") == "json" {
+		c.JSON(200, gin.H{"SID": token, "LSID": token, "Auth": token})
+		return
+	}
+	c.String(200, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+}
+
+// Middleware authenticating by "Authorization: GoogleLogin auth=<token>", setting the user ID as
+// "userid" in the context.
+func googleLoginRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Request.Header.Get("Authorization")
+		const prefix = "GoogleLogin auth="
+		if strings.HasPrefix(header, prefix) {
+			username, err := user.LookupAPIToken(strings.TrimPrefix(header, prefix))
+			if err == nil {
+				c.Set("userid", username)
+				c.Next()
+				return
+			} else if err != user.ErrNotFound {
+				respondStorageError(c, err)
+				c.Abort()
+				return
+			}
+		}
+		c.String(401, "Unauthorized")
+		c.Abort()
+	}
+}
+
+// Fetch the subscriptions of the user once per request.
+func getSourcesCached(c *gin.Context, username string) ([]*streamSource, error) {
+	if srcs, ok := c.Get("compatSources"); ok {
+		return srcs.([]*streamSource), nil
+	}
+	srcs, err := getSources(username)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("compatSources", srcs)
+	return srcs, nil
+}
+
+// Respond 500 to a request failing due to a storage error, which is logged.
+func respondStorageError(c *gin.Context, err error) {
+	log.Printf("[e] Storage error handling %s %s: %v\n", c.Request.Method, c.Request.URL.Path, err)
+	c.String(500, "storage error")
+}
+
+var errUnknownStream = errors.New("unknown stream")
+
+// Select the items of a stream according to the parameters of the request: "xt" to exclude the
+// read state, "ot" and "nt" as oldest and newest publication time in seconds, "r" set to "o" for
+// oldest first, and "n" items from continuation "c". Return the continuation of the next page if
+// any, or errUnknownStream if the stream is unknown.
+func selectStream(c *gin.Context, username, streamID string) ([]*streamItem, string, error) {
+	srcs, err := getSourcesCached(c, username)
+	if err != nil {
+		return nil, "", err
+	}
+	f, ok := parseStream(srcs, streamID)
+	if !ok {
+		return nil, "", errUnknownStream
+	}
+	if normalizeStreamID(c.Query("xt")) == readStream {
+		f.UnreadOnly = true
+	}
+	items, err := collectItems(username, srcs, f)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if ot, err := strconv.ParseInt(c.Query("ot"), 10, 64); err == nil && ot > 0 {
+		var res []*streamItem
+		for _, item := range items {
+			if item.Time.Unix() >= ot {
+				res = append(res, item)
+			}
+		}
+		items = res
+	}
+	if nt, err := strconv.ParseInt(c.Query("nt"), 10, 64); err == nil && nt > 0 {
+		items = publishedUntil(items, time.Unix(nt, 0))
+	}
+	if c.Query("r") != "o" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	const defaultCount, maxCount = 20, 10000
+	n, err := strconv.Atoi(c.Query("n"))
+	if err != nil || n <= 0 {
+		n = defaultCount
+	} else if n > maxCount {
+		n = maxCount
+	}
+	offset, err := strconv.Atoi(c.Query("c"))
+	if err != nil || offset < 0 || offset > len(items) {
+		offset = 0
+	}
+	items = items[offset:]
+	continuation := ""
+	if len(items) > n {
+		items = items[:n]
+		continuation = strconv.Itoa(offset + n)
+	}
+	return items, continuation, nil
+}
+
+// Items published until the given time, including those of unknown time.
+func publishedUntil(items []*streamItem, until time.Time) []*streamItem {
+	var res []*streamItem
+	for _, item := range items {
+		if item.Time.IsZero() || !item.Time.After(until) {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// Parse the stream ID into the filter of its items, return false if unknown.
+func parseStream(srcs []*streamSource, streamID string) (streamFilter, bool) {
+	streamID = normalizeStreamID(streamID)
+	switch streamID {
+	case "", readingListStream:
+		return streamFilter{}, true
+	case readStream:
+		return streamFilter{ReadOnly: true}, true
+	case starredStream:
+		return streamFilter{StarredOnly: true}, true
+	}
+	if ref, ok := feed.ParseRef(strings.TrimPrefix(streamID, feedStreamPrefix)); ok && strings.HasPrefix(streamID, feedStreamPrefix) {
+		for _, src := range srcs {
+			if src.Ref == ref {
+				return streamFilter{SourceID: src.SourceID}, true
+			}
+		}
+	}
+	return streamFilter{}, false
+}
+
+// Stream IDs may contain the actual user ID rather than "-".
+func normalizeStreamID(streamID string) string {
+	if parts := strings.SplitN(streamID, "/", 3); len(parts) == 3 && parts[0] == "user" {
+		return "user/-/" + parts[2]
+	}
+	return streamID
+}
+
+func feedStreamID(src *streamSource) string {
+	return feedStreamPrefix + strconv.FormatInt(src.Ref, 10)
+}
+
+// Item IDs come in the long form "tag:google.com,2005:reader/item/<16 hex digits>" or as decimal.
+func parseItemID(id string) (int64, bool) {
+	if strings.HasPrefix(id, itemIDPrefix) {
+		ref, err := strconv.ParseUint(strings.TrimPrefix(id, itemIDPrefix), 16, 64)
+		return int64(ref), err == nil && ref > 0
+	}
+	return feed.ParseRef(id)
+}
+
+// Unix time in seconds, zero if unknown.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// Unix time in microseconds as the API formats it, zero if unknown.
+func usec(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+}
+
+// Write the full contents of the items of a stream.
+func writeContents(c *gin.Context, streamID string, items []*streamItem, continuation string) {
+	res := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		// Items missing content are still listed, as their entries exist.
+		fi, err := feed.GetItem(item.Entry.FeedID)
+		if err != nil && err != feed.ErrNotFound {
+			respondStorageError(c, err)
+			return
+		}
+		categories := []string{readingListStream}
+		if !item.Unread {
+			categories = append(categories, readStream)
+		}
+		if item.Starred {
+			categories = append(categories, starredStream)
+		}
+		res = append(res, gin.H{
+			"id":            fmt.Sprintf("%s%016x", itemIDPrefix, item.Ref),
+			"crawlTimeMsec": strconv.FormatInt(unixTime(item.Time)*1000, 10),
+			"timestampUsec": usec(item.Time),
+			"published":     unixTime(item.Time),
+			"updated":       unixTime(item.Time),
+			"title":         item.Entry.Title,
+			"canonical":     []gin.H{{"href": fi.Link}},
+			"alternate":     []gin.H{{"href": fi.Link, "type": "text/html"}},
+			"summary":       gin.H{"direction": "ltr", "content": fi.Content},
+			"categories":    categories,
+			"origin": gin.H{
+				"streamId": feedStreamID(item.Source),
+				"title":    item.Source.title(),
+				"htmlUrl":  item.Source.Link,
+			},
+		})
+	}
+	body := gin.H{
+		"id":      streamID,
+		"updated": time.Now().Unix(),
+		"items":   res,
+	}
+	if continuation != "" {
+		body["continuation"] = continuation
+	}
+	c.JSON(200, body)
+}
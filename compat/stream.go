@@ -0,0 +1,283 @@
+// Package compat maps the Google Reader and Fever APIs, as spoken by third-party feed reader apps,
+// onto ReadKey's subscriptions and feed items. Both APIs identify items and feed sources by numbers,
+// which are assigned on first use (see feed.GetItemRefs).
+package compat
+
+import (
+	"sort"
+	"time"
+
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
+)
+
+// Feed item as seen by API clients.
+type streamItem struct {
+	Ref    int64
+	Source *streamSource
+	Entry  feed.ItemEntry
+	// Publication time, zero if unknown.
+	Time    time.Time
+	Unread  bool
+	Starred bool
+}
+
+// Subscribed feed source as seen by API clients.
+type streamSource struct {
+	user.Subscription
+	Ref int64
+}
+
+func (s *streamSource) title() string {
+	if s.CustomTitle != "" {
+		return s.CustomTitle
+	}
+	return s.Title
+}
+
+// Selects the items of a stream. Zero value selects all items.
+type streamFilter struct {
+	// Only items of the feed source, if not empty.
+	SourceID    string
+	UnreadOnly  bool
+	ReadOnly    bool
+	StarredOnly bool
+}
+
+// Fetch the subscriptions of a user together with their numeric references.
+func getSources(username string) ([]*streamSource, error) {
+	subs, err := user.GetFeedSubscriptions(username)
+	if err != nil {
+		return nil, err
+	}
+	srcIDs := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		srcIDs = append(srcIDs, sub.SourceID)
+	}
+	refs, err := feed.GetSourceRefs(srcIDs)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*streamSource, 0, len(subs))
+	for i, sub := range subs {
+		res = append(res, &streamSource{sub, refs[i]})
+	}
+	return res, nil
+}
+
+// Collect the items of the user's subscriptions selected by the filter, oldest first. The items
+// known of a feed source are its latest ones, together with those still unread or starred.
+func collectItems(username string, srcs []*streamSource, f streamFilter) ([]*streamItem, error) {
+	starred, err := user.GetStarredFeedIds(username)
+	if err != nil {
+		return nil, err
+	}
+	var res []*streamItem
+	for _, src := range srcs {
+		if f.SourceID != "" && src.SourceID != f.SourceID {
+			continue
+		}
+		items, err := collectSourceItems(username, src, starred, f)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, items...)
+	}
+	sortItems(res)
+	return res, nil
+}
+
+func collectSourceItems(username string, src *streamSource, starred map[string]string, f streamFilter) ([]*streamItem, error) {
+	unreadIDs, err := user.GetUnreadFeedIds(username, src.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	recentIDs, err := feed.GetRecentItemIdsFromSource(src.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	unread := make(map[string]bool, len(unreadIDs))
+	for _, id := range unreadIDs {
+		unread[id] = true
+	}
+
+	var feedIDs []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		isUnread, isStarred := unread[id], starred[id] == src.SourceID
+		if (f.UnreadOnly && !isUnread) || (f.ReadOnly && isUnread) || (f.StarredOnly && !isStarred) {
+			return
+		}
+		feedIDs = append(feedIDs, id)
+	}
+	for _, id := range recentIDs {
+		add(id)
+	}
+	for _, id := range unreadIDs {
+		add(id)
+	}
+	for id, srcID := range starred {
+		if srcID == src.SourceID {
+			add(id)
+		}
+	}
+
+	entries, err := feed.GetItemEntriesFromSource(src.SourceID, feedIDs)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*streamItem, 0, len(entries))
+	for _, entry := range entries {
+		t, _ := util.ParseFeedDate(entry.PubDate)
+		items = append(items, &streamItem{
+			Source:  src,
+			Entry:   entry,
+			Time:    t,
+			Unread:  unread[entry.FeedID],
+			Starred: starred[entry.FeedID] == src.SourceID,
+		})
+	}
+	sortItems(items)
+	// Assign references oldest first, so that they increase with time.
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Entry.FeedID)
+	}
+	refs, err := feed.GetItemRefs(src.SourceID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		item.Ref = refs[i]
+	}
+	return items, nil
+}
+
+// Sort items oldest first.
+func sortItems(items []*streamItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].Time.Equal(items[j].Time) {
+			return items[i].Time.Before(items[j].Time)
+		}
+		return items[i].Ref < items[j].Ref
+	})
+}
+
+// Mark the unread items among the given ones as read.
+func markRead(username string, items []*streamItem) error {
+	bySource := make(map[string][]string)
+	for _, item := range items {
+		if item.Unread {
+			bySource[item.Source.SourceID] = append(bySource[item.Source.SourceID], item.Entry.FeedID)
+			item.Unread = false
+		}
+	}
+	for srcID, feedIDs := range bySource {
+		if err := user.RemoveUnreadFeedItemIDs(username, srcID, feedIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve numeric item references to items of the user's subscriptions, unknown ones or those of
+// feed sources not subscribed are left out.
+func resolveItems(srcs []*streamSource, refs []int64) (map[int64]feed.ItemRef, error) {
+	res, err := feed.LookupItemRefs(refs)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := make(map[string]bool, len(srcs))
+	for _, src := range srcs {
+		subscribed[src.SourceID] = true
+	}
+	for ref, item := range res {
+		if !subscribed[item.SourceID] {
+			delete(res, ref)
+		}
+	}
+	return res, nil
+}
+
+// Apply the edits to an item: "read", "unread", "star" or "unstar".
+func editItem(username string, item feed.ItemRef, edits ...string) error {
+	for _, edit := range edits {
+		var err error
+		switch edit {
+		case "read":
+			err = user.RemoveUnreadFeedItemID(username, item.SourceID, item.FeedID)
+		case "unread":
+			err = user.AppendUnreadFeedItemID(username, item.SourceID, item.FeedID)
+		case "star":
+			err = user.StarFeedItem(username, item.SourceID, item.FeedID)
+		case "unstar":
+			err = user.UnstarFeedItem(username, item.FeedID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load the items of the given references among the user's subscriptions, oldest first, fetching only
+// their entries rather than collecting all items. Unknown references are left out.
+func loadItems(username string, srcs []*streamSource, refs []int64) ([]*streamItem, error) {
+	resolved, err := resolveItems(srcs, refs)
+	if err != nil || len(resolved) == 0 {
+		return nil, err
+	}
+	starred, err := user.GetStarredFeedIds(username)
+	if err != nil {
+		return nil, err
+	}
+	refsBySource := make(map[string]map[string]int64)
+	for ref, item := range resolved {
+		if refsBySource[item.SourceID] == nil {
+			refsBySource[item.SourceID] = make(map[string]int64)
+		}
+		refsBySource[item.SourceID][item.FeedID] = ref
+	}
+
+	var res []*streamItem
+	for _, src := range srcs {
+		feedRefs := refsBySource[src.SourceID]
+		if feedRefs == nil {
+			continue
+		}
+		feedIDs := make([]string, 0, len(feedRefs))
+		for id := range feedRefs {
+			feedIDs = append(feedIDs, id)
+		}
+		entries, err := feed.GetItemEntriesFromSource(src.SourceID, feedIDs)
+		if err != nil {
+			return nil, err
+		}
+		unreadIDs, err := user.GetUnreadFeedIds(username, src.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		unread := make(map[string]bool, len(unreadIDs))
+		for _, id := range unreadIDs {
+			unread[id] = true
+		}
+		for _, entry := range entries {
+			t, _ := util.ParseFeedDate(entry.PubDate)
+			res = append(res, &streamItem{
+				Ref:     feedRefs[entry.FeedID],
+				Source:  src,
+				Entry:   entry,
+				Time:    t,
+				Unread:  unread[entry.FeedID],
+				Starred: starred[entry.FeedID] == src.SourceID,
+			})
+		}
+	}
+	sortItems(res)
+	return res, nil
+}
@@ -0,0 +1,252 @@
+// Package digest emails users digests of their unread items with extracted keywords, daily or
+// weekly as set in their preferences, through the SMTP server configured by environment variables:
+//
+//	SMTP_ADDR      host:port of the server, digests are disabled if unset
+//	SMTP_USERNAME  username for PLAIN authentication if any, requiring TLS unless on localhost
+//	SMTP_PASSWORD  password for PLAIN authentication
+//	SMTP_FROM      sender address, "readkey@<host of SMTP_ADDR>" by default
+package digest
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/keyword"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
+)
+
+// Interval of checking which digests are due.
+const checkInterval = 10 * time.Minute
+
+// ErrNotConfigured is returned when sending a digest without an SMTP server configured.
+var ErrNotConfigured = errors.New("no SMTP server configured")
+
+var mailer *smtpMailer
+
+// MailError is returned when the SMTP server fails to take a digest, as opposed to storage errors.
+type MailError struct {
+	Err error
+}
+
+func (e MailError) Error() string {
+	return "sending email failed: " + e.Err.Error()
+}
+
+// MarkReadError is returned when a digest was sent, but its items couldn't be marked read.
+type MarkReadError struct {
+	Err error
+}
+
+func (e MarkReadError) Error() string {
+	return "marking digest items read failed: " + e.Err.Error()
+}
+
+// Start loads the SMTP configuration and sends digests as they are due in the background.
+func Start() {
+	mailer = newSMTPMailer()
+	if mailer == nil {
+		log.Printf("[i] Email digests are disabled as SMTP_ADDR isn't set\n")
+		return
+	}
+	go func() {
+		for {
+			sendDue(time.Now())
+			<-time.After(checkInterval)
+		}
+	}()
+}
+
+// Send compiles the digest of a user's unread items stored since `since` and sends it right away,
+// return the number of items in it. Nothing is sent if there is none. Return MarkReadError with the
+// number of items if they are to be marked read and that fails once sent.
+func Send(username string, prefs user.DigestPrefs, since time.Time) (int, error) {
+	if mailer == nil {
+		return 0, ErrNotConfigured
+	}
+	d, err := compile(username, prefs, since)
+	if err != nil || d.Total == 0 {
+		return 0, err
+	}
+	if err := mailer.send(prefs.Email, d); err != nil {
+		return 0, MailError{err}
+	}
+	if prefs.MarkRead {
+		for _, g := range d.Groups {
+			if err := user.RemoveUnreadFeedItemIDs(username, g.SourceID, g.feedIDs()); err != nil {
+				return d.Total, MarkReadError{err}
+			}
+		}
+	}
+	return d.Total, nil
+}
+
+// Send the digests due at `now`.
+func sendDue(now time.Time) {
+	all, err := user.GetAllDigestPrefs()
+	if err != nil {
+		log.Printf("[e] Failed to get digest preferences: %v\n", err)
+		return
+	}
+	for username, prefs := range all {
+		scheduled, ok := lastScheduled(prefs, now)
+		if !ok {
+			continue
+		}
+		sent, err := user.GetDigestSent(username)
+		if err != nil {
+			log.Printf("[e] Failed to get last digest of %s: %v\n", username, err)
+			continue
+		} else if sent >= scheduled.Unix() {
+			continue
+		}
+		// Items since the last digest, or of the last period for the first one.
+		since := time.Unix(sent, 0)
+		if sent == 0 {
+			since = scheduled.Add(-period(prefs))
+		}
+		n, err := Send(username, prefs, since)
+		if _, sent := err.(MarkReadError); sent {
+			// Recorded all the same, rather than sent again until the items can be marked read.
+			log.Printf("[e] Failed to mark digest items of %s read: %v\n", username, err)
+		} else if err != nil {
+			log.Printf("[e] Failed to send digest to %s: %v\n", username, err)
+			continue
+		}
+		if err := user.SetDigestSent(username, now.Unix()); err != nil {
+			log.Printf("[e] Failed to record digest of %s: %v\n", username, err)
+		}
+		log.Printf("[i] Sent digest of %d item(s) to %s\n", n, username)
+	}
+}
+
+// The last time a digest is scheduled at up to `now`, return false if digests are off.
+func lastScheduled(prefs user.DigestPrefs, now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), prefs.Hour, 0, 0, 0, time.UTC)
+	switch prefs.Frequency {
+	case user.DigestDaily:
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+	case user.DigestWeekly:
+		t = t.AddDate(0, 0, -((int(now.Weekday()) - prefs.Weekday + 7) % 7))
+		if t.After(now) {
+			t = t.AddDate(0, 0, -7)
+		}
+	default:
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func period(prefs user.DigestPrefs) time.Duration {
+	if prefs.Frequency == user.DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Digest of unread items, which the templates render.
+type digest struct {
+	User      string
+	Frequency string
+	Total     int
+	Groups    []group
+}
+
+// Unread items of a subscription.
+type group struct {
+	SourceID string
+	Title    string
+	Link     string
+	Items    []item
+}
+
+type item struct {
+	FeedID   string
+	Title    string
+	Link     string
+	Keywords []keyword.Keyword
+	PubDate  string
+}
+
+func (g group) feedIDs() []string {
+	ids := make([]string, 0, len(g.Items))
+	for _, it := range g.Items {
+		ids = append(ids, it.FeedID)
+	}
+	return ids
+}
+
+// Collect the unread items of a user stored since `since` by subscription. Items may be stored well
+// after they are published, e.g. when polled late or backdated, so going by publication dates would
+// skip them for good. Entries stored before the time was kept go by their publication date, and are
+// included if it is unknown.
+func compile(username string, prefs user.DigestPrefs, since time.Time) (digest, error) {
+	d := digest{User: username, Frequency: prefs.Frequency}
+	subs, err := user.GetFeedSubscriptions(username)
+	if err != nil {
+		return d, err
+	}
+	for _, sub := range subs {
+		feedIDs, err := user.GetUnreadFeedIds(username, sub.SourceID)
+		if err != nil {
+			return d, err
+		}
+		entries, err := feed.GetItemEntriesFromSource(sub.SourceID, feedIDs)
+		if err != nil {
+			return d, err
+		}
+
+		g := group{SourceID: sub.SourceID, Title: sub.Title, Link: sub.Link}
+		if sub.CustomTitle != "" {
+			g.Title = sub.CustomTitle
+		}
+		for _, entry := range entries {
+			if !addedSince(entry, since) {
+				continue
+			}
+			// Items missing content are still listed, as their entries exist.
+			fi, err := feed.GetItem(entry.FeedID)
+			if err != nil && err != feed.ErrNotFound {
+				return d, err
+			}
+			g.Items = append(g.Items, item{
+				FeedID:   entry.FeedID,
+				Title:    entry.Title,
+				Link:     fi.Link,
+				Keywords: entry.Keywords,
+				PubDate:  entry.PubDate,
+			})
+		}
+		if len(g.Items) > 0 {
+			d.Groups = append(d.Groups, g)
+			d.Total += len(g.Items)
+		}
+	}
+	sort.Slice(d.Groups, func(i, j int) bool {
+		return strings.ToLower(d.Groups[i].Title) < strings.ToLower(d.Groups[j].Title)
+	})
+	return d, nil
+}
+
+func addedSince(entry feed.ItemEntry, since time.Time) bool {
+	if entry.Added != 0 {
+		return entry.Added >= since.Unix()
+	}
+	t, ok := util.ParseFeedDate(entry.PubDate)
+	return !ok || !t.Before(since)
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
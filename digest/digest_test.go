@@ -0,0 +1,196 @@
+package digest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edfward/readkey/keyword"
+	"github.com/edfward/readkey/libstore/storetest"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
+)
+
+// Accept SMTP sessions on a local port and pass each message received.
+func smtpStub(t *testing.T) (addr string, msgs <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func serveSMTP(conn net.Conn, msgs chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 localhost ESMTP stub")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			msgs <- msg.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// Set up a user with two subscriptions, each with an unread item stored now and the first with one
+// stored long ago.
+func setup(t *testing.T) (*storetest.Store, <-chan string) {
+	store := storetest.New()
+	feed.Setup(store)
+	user.Setup(store)
+	addr, msgs := smtpStub(t)
+	mailer = &smtpMailer{addr: addr, from: "readkey@localhost"}
+	t.Cleanup(func() { mailer = nil })
+
+	now := time.Now().Unix()
+	subs := []struct {
+		sub     user.Subscription
+		entries []feed.ItemEntry
+	}{
+		{user.Subscription{Source: feed.Source{SourceID: "src:zeta", Title: "Zeta"}}, []feed.ItemEntry{
+			{FeedID: "feed:z1", Title: "Zeta news", Added: now, Keywords: []keyword.Keyword{{Text: "zeta", Score: 1}}},
+			{FeedID: "feed:z0", Title: "Old zeta news", Added: now - 30*24*3600},
+		}},
+		{user.Subscription{Source: feed.Source{SourceID: "src:alpha", Title: "Alpha"}, CustomTitle: "alpha blog"}, []feed.ItemEntry{
+			{FeedID: "feed:a1", Title: "Alpha post", Added: now},
+		}},
+	}
+	for _, s := range subs {
+		packet, _ := json.Marshal(s.sub)
+		store.HSet(util.FormatUserSubsKey("alice"), s.sub.SourceID, string(packet))
+		for _, e := range s.entries {
+			packet, _ := json.Marshal(e)
+			store.HSet(s.sub.SourceID, e.FeedID, string(packet))
+			store.SAdd(util.FormatUserUnreadKey("alice", s.sub.SourceID), e.FeedID)
+		}
+	}
+	// Content of the other items is missing, which leaves them without link.
+	store.HSet("feed:z1", "link", "https://zeta.example.com/1")
+	store.HSet("feed:z1", "content", "Zeta")
+	return store, msgs
+}
+
+func TestSendCompilesAndMarksRead(t *testing.T) {
+	store, msgs := setup(t)
+	prefs := user.DigestPrefs{Email: "alice@example.com", Frequency: user.DigestDaily, MarkRead: true}
+
+	n, err := Send("alice", prefs, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("sent %d items, want 2", n)
+	}
+	var msg string
+	select {
+	case msg = <-msgs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	for _, want := range []string{"To: alice@example.com", "Subject: Your daily ReadKey digest (2 unread)",
+		"Zeta news", "Alpha post", "https://zeta.example.com/1", "Keywords: zeta"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "Old zeta news") {
+		t.Error("message lists an item stored before the period")
+	}
+	// Grouped by subscription, ordered by title regardless of case.
+	if a, z := strings.Index(msg, "<h2>alpha blog</h2>"), strings.Index(msg, "<h2>Zeta</h2>"); a < 0 || z < 0 || a > z {
+		t.Errorf("groups out of order:\n%s", msg)
+	}
+
+	zetaUnread := store.Sets[util.FormatUserUnreadKey("alice", "src:zeta")]
+	alphaUnread := store.Sets[util.FormatUserUnreadKey("alice", "src:alpha")]
+	if zetaUnread["feed:z1"] || alphaUnread["feed:a1"] {
+		t.Error("items sent are still unread")
+	}
+	if !zetaUnread["feed:z0"] {
+		t.Error("item left out was marked read")
+	}
+}
+
+func TestSendKeepsUnread(t *testing.T) {
+	store, msgs := setup(t)
+	prefs := user.DigestPrefs{Email: "alice@example.com", Frequency: user.DigestWeekly}
+
+	if n, err := Send("alice", prefs, time.Now().Add(-24*time.Hour)); err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 items sent", n, err)
+	}
+	<-msgs
+	if !store.Sets[util.FormatUserUnreadKey("alice", "src:zeta")]["feed:z1"] {
+		t.Error("item marked read without MarkRead")
+	}
+}
+
+func TestSendDueRecordsDespiteMarkReadFailure(t *testing.T) {
+	store, msgs := setup(t)
+	store.Errors["SREM"] = errors.New("connection reset")
+	now := time.Now().UTC()
+	prefs := user.DigestPrefs{Email: "alice@example.com", Frequency: user.DigestDaily, Hour: now.Hour(), MarkRead: true}
+	packet, _ := json.Marshal(prefs)
+	store.HSet(util.FormatDigestPrefsKey(), "alice", string(packet))
+
+	if _, err := Send("alice", prefs, now.Add(-time.Hour)); err == nil {
+		t.Fatal("succeeded, want MarkReadError")
+	} else if _, sent := err.(MarkReadError); !sent {
+		t.Fatalf("got %T %v, want MarkReadError", err, err)
+	}
+	<-msgs
+
+	sendDue(now)
+	<-msgs
+	if sent, err := user.GetDigestSent("alice"); err != nil || sent != now.Unix() {
+		t.Errorf("recorded %d, %v, want the digest recorded as sent at %d", sent, err, now.Unix())
+	}
+	// Not sent again.
+	sendDue(now.Add(checkInterval))
+	select {
+	case <-msgs:
+		t.Error("digest sent again")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,125 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var textDigest = template.Must(template.New("digest.txt").Parse(`Your {{.Frequency}} ReadKey digest: {{.Total}} unread item(s).
+{{range .Groups}}
+== {{.Title}} ==
+{{range .Items}}
+* {{.Title}}{{if .Link}}
+  {{.Link}}{{end}}{{if .Keywords}}
+  Keywords: {{range $i, $kw := .Keywords}}{{if $i}}, {{end}}{{$kw.Text}}{{end}}{{end}}
+{{end}}{{end}}`))
+
+var htmlDigest = htmltemplate.Must(htmltemplate.New("digest.html").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>ReadKey Digest</title></head>
+<body>
+  <p>Your {{.Frequency}} ReadKey digest: {{.Total}} unread item(s).</p>
+  {{range .Groups}}
+  <h2>{{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h2>
+  <ul>
+    {{range .Items}}
+    <li>
+      {{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}
+      {{if .Keywords}}<br><small>{{range $i, $kw := .Keywords}}{{if $i}} · {{end}}{{$kw.Text}}{{end}}</small>{{end}}
+    </li>
+    {{end}}
+  </ul>
+  {{end}}
+</body>
+</html>
+`))
+
+// Sends digests through an SMTP server.
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// Configure the mailer by environment variables, return nil if no SMTP server is set.
+func newSMTPMailer() *smtpMailer {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	m := &smtpMailer{addr: addr, from: getenv("SMTP_FROM", "readkey@"+host)}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		m.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return m
+}
+
+// Render the digest as a multipart/alternative message of plain text and HTML, and send it.
+func (m *smtpMailer) send(to string, d digest) error {
+	var text, html bytes.Buffer
+	if err := textDigest.Execute(&text, d); err != nil {
+		return err
+	}
+	if err := htmlDigest.Execute(&html, d); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain", text.Bytes()}, {"text/html", html.Bytes()}} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	parts.Close()
+
+	var msg bytes.Buffer
+	header := []string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: Your " + d.Frequency + " ReadKey digest (" + strconv.Itoa(d.Total) + " unread)",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(m.from),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	msg.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	msg.Write(body.Bytes())
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, msg.Bytes())
+}
+
+func messageID(from string) string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "readkey"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
@@ -0,0 +1,97 @@
+// Package event publishes events of users' subscriptions, e.g. new feed items, to be pushed to
+// their connected clients. Events are sent through Redis pub/sub so that they reach clients
+// connected to any server instance, and are lost if no client is listening.
+package event
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/edfward/readkey/libstore"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var rs libstore.RedisStrore
+
+// Setup must be called before other functions to configure the Redis store.
+func Setup(store libstore.RedisStrore) {
+	rs = store
+}
+
+// NewItem is the event of a new feed item added to the user's unread items of a subscribed source.
+type NewItem struct {
+	SourceID string         `json:"sourceId"`
+	Entry    feed.ItemEntry `json:"entry"`
+	// Number of unread items of the source after adding it.
+	UnreadCount int64 `json:"unreadCount"`
+}
+
+// PublishNewItem sends the event to the user's listeners, if any.
+func PublishNewItem(user string, ev NewItem) error {
+	packet, _ := json.Marshal(ev)
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("PUBLISH", util.FormatUserEventsKey(user), packet)
+		return err
+	})
+}
+
+// Listener receives the events of a user until closed.
+type Listener struct {
+	// Events received, closed when the listener is closed or its connection breaks.
+	C <-chan NewItem
+
+	conn      redis.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen starts receiving the events published to the user. The listener keeps a Redis connection
+// of its own, which is released by `Close`.
+func Listen(user string) (*Listener, error) {
+	conn := rs.GetConnection()
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(util.FormatUserEventsKey(user)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ch := make(chan NewItem)
+	l := &Listener{C: ch, conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(ch)
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				var ev NewItem
+				if err := json.Unmarshal(v.Data, &ev); err != nil {
+					log.Printf("[e] Failed to decode event of %s: %v\n", user, err)
+					continue
+				}
+				select {
+				case ch <- ev:
+				case <-l.done:
+					return
+				}
+			case error:
+				select {
+				case <-l.done:
+				default:
+					log.Printf("[e] Stopped receiving events of %s: %v\n", user, v)
+				}
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// Close stops receiving events and releases the connection.
+func (l *Listener) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.conn.Close()
+	})
+}
@@ -2,10 +2,17 @@ package feeder
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/edfward/readkey/keyword"
 	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
 
 	rss "github.com/jteeuwen/go-pkg-rss"
 )
@@ -17,68 +24,180 @@ type Feeder interface {
 
 // Feed manager.
 type feeder struct {
-	// Mapping from atom/rss URL to corresponding info.
-	urlToFeedSrc          map[string]feed.Source
-	urlToFeedSrcLock      *sync.Mutex
-	keywordServerEndPoint string
+	// Mapping from normalized atom/rss URL to corresponding info. URLs a source moved away from
+	// are kept as aliases to the same info.
+	urlToFeedSrc map[string]feed.Source
+	// Mapping from feed source ID to the handler polling it. Also guarded by `urlToFeedSrcLock`.
+	listeners        map[string]*feedHandler
+	urlToFeedSrcLock *sync.Mutex
+	// Extracts the keywords of new feed items.
+	kwFetcher keyword.Fetcher
 }
 
 // NewFeeder builds the feeder and start the background goroutine.
-func NewFeeder(keywordServerEndPoint string) Feeder {
+func NewFeeder(kwFetcher keyword.Fetcher) Feeder {
 	fd := &feeder{
-		urlToFeedSrc:          make(map[string]feed.Source),
-		urlToFeedSrcLock:      &sync.Mutex{},
-		keywordServerEndPoint: keywordServerEndPoint,
+		urlToFeedSrc:     make(map[string]feed.Source),
+		listeners:        make(map[string]*feedHandler),
+		urlToFeedSrcLock: &sync.Mutex{},
+		kwFetcher:        kwFetcher,
 	}
 	// Try to re-listen to feed sources if existing, as a recovery method.
 	fd.recover()
 	return fd
 }
 
-// Requires the URL to exact match actual feed's URL.
+// Requires the URL to match actual feed's URL or a URL it has moved from, after normalization.
 func (f *feeder) GetFeedSource(url string) (feed.Source, error) {
 	f.urlToFeedSrcLock.Lock()
 	defer f.urlToFeedSrcLock.Unlock()
 
-	if src, ok := f.urlToFeedSrc[url]; ok {
+	url = strings.TrimSpace(url)
+	key := util.NormalizeURL(url)
+	if src, ok := f.urlToFeedSrc[key]; ok {
 		return src, nil
 	}
 
 	newSrcCh := make(chan feed.Source)
 	// Buffered so the other side will not block even if the current function returns.
 	errCh := make(chan error, 1)
-	f.listen(url, newSrcCh, errCh)
+	handler := f.listen(feed.Source{URL: url}, newSrcCh, errCh)
 
 	select {
 	case src := <-newSrcCh:
-		f.urlToFeedSrc[src.URL] = src
-		feed.AppendListeningSource(src)
+		srcKey := util.NormalizeURL(src.URL)
+		if known, ok := f.urlToFeedSrc[srcKey]; ok {
+			// The URL permanently redirects to a known feed source, whose items the new handler left
+			// to the known one. It's never registered so it stops after the current fetch.
+			f.urlToFeedSrc[key] = known
+			if err := feed.AddSourceAlias(url, known.SourceID); err != nil {
+				log.Printf("[e] Failed to add alias of feed source %s: %v\n", known.URL, err)
+			}
+			return known, nil
+		}
+		// Store the source first, so that it's listened to again after a restart.
+		if err := feed.AppendListeningSource(src); err != nil {
+			return feed.Source{}, errors.New("storing feed source failed: " + err.Error())
+		}
+		f.urlToFeedSrc[srcKey] = src
+		if srcKey != key {
+			f.urlToFeedSrc[key] = src
+			if err := feed.AddSourceAlias(url, src.SourceID); err != nil {
+				log.Printf("[e] Failed to add alias of feed source %s: %v\n", src.URL, err)
+			}
+		}
+		f.listeners[src.SourceID] = handler
 		return src, nil
 	case err := <-errCh:
 		return feed.Source{}, errors.New("subscribe failed: " + err.Error())
 	}
 }
 
-// When encountering a new feed source, begin listening if valid.
-func (f *feeder) listen(url string, newSrcCh chan<- feed.Source, errCh chan<- error) {
+// When encountering a new feed source, begin listening if valid. `src` only carries the URL
+// for a new source, or the stored record when re-listening to a known one. The listening goroutine
+// exits once the returned handler is no longer registered in `listeners` (except before a new source
+// is sent back).
+func (f *feeder) listen(src feed.Source, newSrcCh chan<- feed.Source, errCh chan<- error) *feedHandler {
 	const timeout = 5
-	handler := newFeedHandler(newSrcCh, f.keywordServerEndPoint)
+	handler := newFeedHandler(src, newSrcCh, f.kwFetcher)
+	handler.onSourceChange = f.updateSource
+	if newSrcCh != nil {
+		handler.isKnown = f.isKnownURL
+	}
 	rssFeed := rss.NewWithHandlers(timeout, true, nil, handler)
 	rssFeed.IgnoreCacheOnce()
+	client := &http.Client{
+		CheckRedirect: handler.checkRedirect,
+		Transport:     roundTripperFunc(handler.roundTrip),
+		Timeout:       30 * time.Second,
+	}
 
 	go func() {
 		for {
-			if err := rssFeed.Fetch(url, nil); err != nil {
-				// Fetch failed, could be an invalid source. Exit.
+			handler.resetFetchState()
+			err := rssFeed.FetchClient(handler.src.URL, client, nil)
+			if err == nil && handler.lastStatus >= 400 {
+				err = fmt.Errorf("unexpected HTTP status %d", handler.lastStatus)
+			}
+			if handler.newSrcCh != nil {
+				// Fetch failed, could be an invalid source. Or no items processed, so the new source
+				// would never be sent back. Exit.
+				if err == nil {
+					err = errors.New("no feed items found")
+				}
 				if errCh != nil {
 					// Will not block since it's buffered.
 					errCh <- err
 				}
 				return
 			}
-			<-time.After(time.Duration(rssFeed.SecondsTillUpdate() * 1e9))
+			if !f.isListening(handler) {
+				log.Printf("[i] Stopped listening to %s\n", handler.src.URL)
+				return
+			}
+
+			wait := time.Duration(rssFeed.SecondsTillUpdate()) * time.Second
+			if err != nil {
+				// Keep polling an established source, but back off while it keeps failing.
+				if delay := retryDelay(f.recordFailure(handler, err)); delay > wait {
+					wait = delay
+				}
+			} else if handler.lastStatus != 0 {
+				// Otherwise the feed wasn't due for an update, so nothing was fetched.
+				if err := feed.RecordFetchSuccess(handler.channelID, handler.lastStatus); err != nil {
+					log.Printf("[e] Failed to record fetch success of %s: %v\n", handler.src.URL, err)
+				}
+				if moved := handler.movedURL; moved != "" && moved != handler.src.URL {
+					if merged := f.moveSource(handler, moved); merged {
+						return
+					}
+				}
+			}
+			<-time.After(wait)
 		}
 	}()
+	return handler
+}
+
+// Record a failed fetch to the health of the feed source, return the number of consecutive failures.
+func (f *feeder) recordFailure(h *feedHandler, err error) int64 {
+	// A response was received fine, but the document couldn't be parsed.
+	parseError := h.lastStatus != 0 && h.lastStatus < 400
+	failures, storeErr := feed.RecordFetchFailure(h.channelID, h.lastStatus, err.Error(), parseError)
+	if storeErr != nil {
+		log.Printf("[e] Failed to record fetch failure of %s: %v\n", h.src.URL, storeErr)
+		// Back off as for a first failure.
+		failures = 1
+	}
+	log.Printf("[e] Failed to fetch %s (%d time(s) in a row): %v\n", h.src.URL, failures, err)
+	return failures
+}
+
+// Delay before fetching a failing feed source again, doubling with each failure up to a day.
+func retryDelay(failures int64) time.Duration {
+	const minDelay, maxDelay = 5 * time.Minute, 24 * time.Hour
+	delay := minDelay
+	for i := int64(1); i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// Whether the URL is of a known feed source. Only called by the handler of a new source, while
+// `GetFeedSource` holds the lock waiting for it.
+func (f *feeder) isKnownURL(url string) bool {
+	_, ok := f.urlToFeedSrc[util.NormalizeURL(url)]
+	return ok
+}
+
+// Whether the handler still polls its feed source, i.e. the source is neither a duplicate nor merged.
+func (f *feeder) isListening(h *feedHandler) bool {
+	f.urlToFeedSrcLock.Lock()
+	defer f.urlToFeedSrcLock.Unlock()
+	return f.listeners[h.channelID] == h
 }
 
 // Recovery happens when starting after the server accidentally exits. Primarily it reconstructs the URL to
@@ -86,9 +205,153 @@ func (f *feeder) listen(url string, newSrcCh chan<- feed.Source, errCh chan<- er
 // 1. Every feed item will be regarded as new to subscribed users. (Hopefully acceptable.)
 // 2. In this way, latest feeds for each source may contain duplicate items. (Also hopefully acceptable.)
 func (f *feeder) recover() {
-	listeningSrcs := feed.GetListeningSources()
+	if err := feed.MigrateListeningSources(); err != nil {
+		log.Printf("[e] Failed to migrate listening feed sources: %v\n", err)
+	}
+	srcs, err := feed.GetListeningSources()
+	if err != nil {
+		log.Printf("[e] Failed to get listening feed sources, none is listened to: %v\n", err)
+	}
+	aliases, err := feed.GetSourceAliases()
+	if err != nil {
+		log.Printf("[e] Failed to get aliases of feed sources: %v\n", err)
+	}
+	srcIDs := make([]string, 0, len(srcs))
+	for _, src := range srcs {
+		srcIDs = append(srcIDs, src.SourceID)
+	}
+	if err := feed.MigrateSourceSubscribers(srcIDs); err != nil {
+		log.Printf("[e] Failed to migrate subscribers of feed sources: %v\n", err)
+	}
+	if err := feed.MigrateItemEntries(srcIDs); err != nil {
+		log.Printf("[e] Failed to migrate feed entries: %v\n", err)
+	}
+	listeningSrcs := f.mergeDuplicateSources(srcs)
+
+	f.urlToFeedSrcLock.Lock()
+	defer f.urlToFeedSrcLock.Unlock()
+
+	idToFeedSrc := make(map[string]feed.Source, len(listeningSrcs))
 	for _, src := range listeningSrcs {
-		f.urlToFeedSrc[src.URL] = src
-		f.listen(src.URL, nil, nil)
+		f.urlToFeedSrc[util.NormalizeURL(src.URL)] = src
+		idToFeedSrc[src.SourceID] = src
+	}
+	for url, srcID := range aliases {
+		if src, ok := idToFeedSrc[srcID]; ok {
+			f.urlToFeedSrc[util.NormalizeURL(url)] = src
+		}
+	}
+	for _, src := range listeningSrcs {
+		f.listeners[src.SourceID] = f.listen(src, nil, nil)
+	}
+}
+
+// Replace the known record of a feed source after it changed, both in memory (including aliases)
+// and in storage, then propagate it to the copies kept in subscribers' subscriptions.
+func (f *feeder) updateSource(src feed.Source) {
+	f.urlToFeedSrcLock.Lock()
+	for url, known := range f.urlToFeedSrc {
+		if known.SourceID == src.SourceID {
+			f.urlToFeedSrc[url] = src
+		}
+	}
+	f.urlToFeedSrc[util.NormalizeURL(src.URL)] = src
+	f.urlToFeedSrcLock.Unlock()
+
+	if err := feed.AppendListeningSource(src); err != nil {
+		log.Printf("[e] Failed to update feed source %s: %v\n", src.URL, err)
+	}
+	subscribers, err := feed.GetSourceSubscribers(src.SourceID)
+	if err != nil {
+		log.Printf("[e] Failed to get subscribers of %s: %v\n", src.URL, err)
+	}
+	for _, username := range subscribers {
+		if err := user.RefreshFeedSubscription(username, src); err != nil {
+			log.Printf("[e] Failed to update subscription of %s to %s: %v\n", username, src.URL, err)
+		}
+	}
+}
+
+// Follow a feed source which permanently moved to `newURL`: it keeps its ID and polls the new URL,
+// while the old URL stays as an alias. If another source is already known by the new URL, the
+// moved one is merged into it instead and true is returned, as its handler should stop.
+func (f *feeder) moveSource(h *feedHandler, newURL string) (merged bool) {
+	f.urlToFeedSrcLock.Lock()
+	known, ok := f.urlToFeedSrc[util.NormalizeURL(newURL)]
+	f.urlToFeedSrcLock.Unlock()
+	if ok && known.SourceID != h.channelID {
+		log.Printf("[i] Feed %s moved to %s, merging into the existing source\n", h.src.URL, newURL)
+		f.mergeSource(h.src, known)
+		return true
+	}
+
+	log.Printf("[i] Feed %s moved to %s\n", h.src.URL, newURL)
+	if err := feed.AddSourceAlias(h.src.URL, h.channelID); err != nil {
+		log.Printf("[e] Failed to add alias of feed source %s: %v\n", newURL, err)
+	}
+	h.src.URL = newURL
+	h.src.Origin = ""
+	if newURL != h.channelURL {
+		h.src.Origin = h.channelURL
+	}
+	f.updateSource(h.src)
+	return false
+}
+
+// Merge a duplicate feed source into another one: subscribers of `from` are moved over to `into`
+// keeping their settings and unread items, all URLs of `from` become aliases of `into`, and `from`
+// is no longer listened to.
+func (f *feeder) mergeSource(from, into feed.Source) {
+	aliases := []string{from.URL}
+	f.urlToFeedSrcLock.Lock()
+	for url, known := range f.urlToFeedSrc {
+		if known.SourceID == from.SourceID {
+			f.urlToFeedSrc[url] = into
+			aliases = append(aliases, url)
+		}
+	}
+	delete(f.listeners, from.SourceID)
+	f.urlToFeedSrcLock.Unlock()
+
+	for _, url := range aliases {
+		if err := feed.AddSourceAlias(url, into.SourceID); err != nil {
+			log.Printf("[e] Failed to add alias of feed source %s: %v\n", into.URL, err)
+		}
+	}
+	if err := feed.MergeSourceEntries(from.SourceID, into.SourceID); err != nil {
+		log.Printf("[e] Failed to merge entries of %s into %s: %v\n", from.URL, into.URL, err)
+	}
+	subscribers, err := feed.GetSourceSubscribers(from.SourceID)
+	if err != nil {
+		// Keep the duplicate source, so that its subscribers are merged on next recovery.
+		log.Printf("[e] Failed to get subscribers of %s: %v\n", from.URL, err)
+		return
+	}
+	for _, username := range subscribers {
+		if err := user.MergeFeedSubscription(username, from.SourceID, into); err != nil {
+			log.Printf("[e] Failed to merge subscription of %s into %s: %v\n", username, into.URL, err)
+			return
+		}
+	}
+	if err := feed.RemoveSource(from.SourceID); err != nil {
+		log.Printf("[e] Failed to remove feed source %s: %v\n", from.URL, err)
+	}
+}
+
+// Merge listening sources whose URLs normalize to the same key, as they were subscribed separately
+// before URLs got normalized. Return the remaining sources.
+func (f *feeder) mergeDuplicateSources(srcs []feed.Source) []feed.Source {
+	keyToFeedSrc := make(map[string]feed.Source, len(srcs))
+	res := make([]feed.Source, 0, len(srcs))
+	for _, src := range srcs {
+		key := util.NormalizeURL(src.URL)
+		if kept, ok := keyToFeedSrc[key]; ok {
+			log.Printf("[i] Merging duplicate feed source %s into %s\n", src.URL, kept.URL)
+			f.mergeSource(src, kept)
+			continue
+		}
+		keyToFeedSrc[key] = src
+		res = append(res, src)
 	}
+	return res
 }
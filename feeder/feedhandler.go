@@ -1,20 +1,29 @@
 package feeder
 
 import (
+	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"html"
 	"log"
+	"net/http"
 	"regexp"
 	"sync"
 	"time"
 
+	"github.com/edfward/readkey/backfill"
+	"github.com/edfward/readkey/event"
 	"github.com/edfward/readkey/keyword"
+	"github.com/edfward/readkey/langdetect"
 	"github.com/edfward/readkey/model/feed"
 	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/summary"
 	"github.com/edfward/readkey/util"
+	"github.com/edfward/readkey/webhook"
 
 	rss "github.com/jteeuwen/go-pkg-rss"
+	"github.com/kennygrant/sanitize"
 )
 
 // A utility regular expression pattern to find RSS feed content using RSS1.0 Content Module Specification.
@@ -26,21 +35,77 @@ type feedHandler struct {
 	seenItems       []string
 	keepSeenItemNum int
 	channelID       string
-	channelURL      string
+	// The URL the source was first listened at, which item IDs are based on. Unlike the URL of
+	// the source, it stays the same when the feed moves.
+	channelURL string
+	// Latest record of the feed source, to detect changes upstream.
+	src feed.Source
+	// Target of the permanent redirects followed by the last fetch, if any.
+	movedURL string
+	// Whether all redirects followed by the last fetch so far are permanent.
+	permanentOnly bool
+	// Status code of the last response received, zero if none.
+	lastStatus int
+	// Called with the updated feed source when the channel metadata changes.
+	onSourceChange func(feed.Source)
+	// Whether the URL is of a known feed source, for a new source which could redirect to one.
+	isKnown func(url string) bool
 	// Fetcher for keywords or summaries.
 	kwFetcher keyword.Fetcher
 }
 
-func newFeedHandler(newSrcCh chan<- feed.Source, keywordServerEndPoint string) *feedHandler {
-	return &feedHandler{
+// The feed source `src` is empty except for the URL when the source is new, otherwise it is
+// the stored record of the source.
+func newFeedHandler(src feed.Source, newSrcCh chan<- feed.Source, kwFetcher keyword.Fetcher) *feedHandler {
+	h := &feedHandler{
 		newSrcCh:        newSrcCh,
 		seenItems:       nil,
-		keepSeenItemNum: 50, // Default value.
-		channelURL:      "", // Canonical URL acquired in `ProcessItems`.
-		channelID:       "", // Hash of the channel URL.
-		// The keyword server address such as "http://localhost:4567/keywords".
-		kwFetcher: keyword.NewKeywordFetcher(keywordServerEndPoint),
+		keepSeenItemNum: 50,           // Default value.
+		channelURL:      "",           // Canonical URL acquired in `ProcessItems` for a new source.
+		channelID:       src.SourceID, // Hash of the channel URL.
+		src:             src,
+		kwFetcher:       kwFetcher,
 	}
+	if src.SourceID != "" {
+		h.channelURL = src.URL
+		if src.Origin != "" {
+			h.channelURL = src.Origin
+		}
+	}
+	return h
+}
+
+// Reset the redirect and status tracking before each fetch.
+func (h *feedHandler) resetFetchState() {
+	h.movedURL = ""
+	h.permanentOnly = true
+	h.lastStatus = 0
+}
+
+// Used as the transport of the HTTP client fetching the feed, recording the response status which
+// the rss library doesn't expose.
+func (h *feedHandler) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		h.lastStatus = resp.StatusCode
+	}
+	return resp, err
+}
+
+// Used as `CheckRedirect` of the HTTP client fetching the feed, recording where the feed has moved
+// as long as the redirects are permanent (301 or 308).
+func (h *feedHandler) checkRedirect(req *http.Request, via []*http.Request) error {
+	const maxRedirects = 10
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after too many redirects")
+	}
+	if h.permanentOnly && req.Response != nil &&
+		(req.Response.StatusCode == http.StatusMovedPermanently || req.Response.StatusCode == http.StatusPermanentRedirect) {
+		h.movedURL = req.URL.String()
+	} else {
+		h.permanentOnly = false
+	}
+	return nil
 }
 
 func (h *feedHandler) ProcessItems(rssFeed *rss.Feed, ch *rss.Channel, items []*rss.Item) {
@@ -49,14 +114,42 @@ func (h *feedHandler) ProcessItems(rssFeed *rss.Feed, ch *rss.Channel, items []*
 		h.keepSeenItemNum = len(items)
 	}
 
-	// Handle channel for first time processing. Using hash of URL as the unique ID.
-	if h.channelID == "" {
+	// Handle channel for first time processing. Using hash of the normalized URL as the unique ID.
+	if h.channelURL == "" {
 		h.channelURL = rssFeed.Url
-		h.channelID = getChannelID(h.channelURL)
+		if h.movedURL != "" {
+			// A new source which has already moved, start off with where it is now.
+			h.channelURL = h.movedURL
+		}
+		h.channelID = getChannelID(util.NormalizeURL(h.channelURL))
+		h.src.URL = h.channelURL
+		h.src.SourceID = h.channelID
+		if h.newSrcCh != nil && h.isKnown != nil && h.isKnown(h.channelURL) {
+			// Moved to a known source, whose own handler stores its items. Sent back as is to be
+			// taken as an alias of it.
+			h.newSrcCh <- h.src
+			h.newSrcCh = nil
+			return
+		}
 	}
 
+	// Propagate channel metadata changes of an established source. A new source is sent back
+	// with the latest metadata at the end anyway.
+	title, link := ch.Title, getChannelLink(ch)
+	if h.newSrcCh == nil && (title != h.src.Title || link != h.src.Link) {
+		log.Printf("[i] Channel metadata of %s changed, title %q -> %q\n", rssFeed.Url, h.src.Title, title)
+		h.src.Title, h.src.Link = title, link
+		if h.onSourceChange != nil {
+			h.onSourceChange(h.src)
+		}
+	}
+	h.src.Title, h.src.Link = title, link
+
 	// Get subscribers of the current channel.
-	subscribers := feed.GetSourceSubscribers(h.channelID)
+	subscribers, err := feed.GetSourceSubscribers(h.channelID)
+	if err != nil {
+		log.Printf("[e] Failed to get subscribers of %s: %v\n", rssFeed.Url, err)
+	}
 
 	// Handle items.
 	var newitems []*rss.Item
@@ -72,6 +165,11 @@ func (h *feedHandler) ProcessItems(rssFeed *rss.Feed, ch *rss.Channel, items []*
 	}
 
 	log.Printf("[i] Found %d new items(s) in %s\n", len(newitems), rssFeed.Url)
+	var summaryReaders map[string]bool
+	if len(newitems) > 0 {
+		summaryReaders = h.summaryReaders(subscribers)
+	}
+	summarize := len(summaryReaders) > 0
 	var wg sync.WaitGroup
 	for _, item := range newitems {
 
@@ -87,50 +185,128 @@ func (h *feedHandler) ProcessItems(rssFeed *rss.Feed, ch *rss.Channel, items []*
 			Link:    link,
 			Content: *contentPtr,
 		}
-		feed.SetItem(id, feedItem)
+		if err := feed.SetItem(id, feedItem); err != nil {
+			log.Printf("[e] Failed to store feed item of %s: %v\n", rssFeed.Url, err)
+		}
 
 		// Then append to the feed source's latest queue.
-		feed.AppendLatestItemIDToSource(h.channelID, id)
+		if err := feed.AppendLatestItemIDToSource(h.channelID, id); err != nil {
+			log.Printf("[e] Failed to append latest feed item of %s: %v\n", rssFeed.Url, err)
+		}
 
 		// Then append to subscribers' unread queue.
 		for _, username := range subscribers {
-			user.AppendUnreadFeedItemID(username, h.channelID, id)
+			if err := user.AppendUnreadFeedItemID(username, h.channelID, id); err != nil {
+				log.Printf("[e] Failed to add unread feed item of %s for %s: %v\n", rssFeed.Url, username, err)
+			}
 		}
 
 		// Append to its corresponding feed source by spawning a new goroutine.
 		lang := getLang(item, ch)
+		if lang == "" {
+			lang = langdetect.Detect(item.Title + "\n" + sanitize.HTML(*contentPtr))
+		}
 		wg.Add(1)
-		go func(id, title, pubDate string) {
+		go func(id, title, pubDate, link string) {
 			defer wg.Done()
 			entry := feed.ItemEntry{
 				FeedID:  id,
 				Title:   title,
 				PubDate: pubDate,
+				Lang:    feed.NormalizeLang(lang),
+				Added:   time.Now().Unix(),
+			}
+			if summarize {
+				entry.Summary = summary.Summarize(sanitize.HTML(*contentPtr), lang)
+			}
+			// Requests to the keyword server time out by its client's timeout once sent, which leaves
+			// out waiting for a free slot behind the items of other feeds.
+			keywords, kwErr := h.kwFetcher.Fetch(context.Background(), contentPtr, lang)
+			_, unavailable := kwErr.(keyword.UnavailableError)
+			_, fellBack := kwErr.(keyword.FallbackError)
+			if kwErr != nil && !unavailable && !fellBack {
+				log.Printf("[e] Failed to fetch keywords of feed entry of %s: %v\n", h.src.URL, kwErr)
+			}
+			entry.Keywords = keywords
+			added, err := feed.AddItemEntryToSource(h.channelID, entry)
+			if err != nil {
+				log.Printf("[e] Failed to add feed entry to %s: %v\n", h.src.URL, err)
+				return
+			}
+			if added && (unavailable || fellBack) {
+				// Store the entry without keywords, or with those of the fallback extractor, for
+				// now. They are extracted later on.
+				log.Printf("[w] Queueing keyword extraction of feed entry of %s: %v\n", h.src.URL, kwErr)
+				if err := backfill.Enqueue(h.channelID, id, lang); err != nil {
+					log.Printf("[e] Failed to queue keyword extraction of feed entry of %s: %v\n", h.src.URL, err)
+				}
 			}
-			const retry = 3
-			fetchResCh := h.kwFetcher.Fetch(contentPtr, lang, retry)
-			select {
-			case kw := <-fetchResCh:
-				entry.Keywords = kw
-			// 10 seconds timeout.
-			case <-time.After(10 * time.Second):
+			// Items stored already, e.g. fetched again after a restart, were notified of before.
+			if added {
+				h.deliverWebhooks(subscribers, summaryReaders, entry, link)
+				h.publishNewItem(subscribers, summaryReaders, entry)
 			}
-			feed.AddItemEntryToSource(h.channelID, entry)
-		}(id, item.Title, item.PubDate)
+		}(id, item.Title, item.PubDate, link)
 	}
 	wg.Wait()
 
 	// Send back the newly established feed source if haven't done so. Then nullify the channel.
 	if h.newSrcCh != nil {
-		h.newSrcCh <- feed.Source{
-			URL:      rssFeed.Url,
-			SourceID: h.channelID,
-			Title:    ch.Title,
-		}
+		h.newSrcCh <- h.src
 		h.newSrcCh = nil
 	}
 }
 
+// Subscribers who ask for summaries of the source.
+func (h *feedHandler) summaryReaders(subscribers []string) map[string]bool {
+	res := make(map[string]bool)
+	for _, username := range subscribers {
+		sub, err := user.GetFeedSubscription(username, h.channelID)
+		if err != nil {
+			if err != user.ErrNotFound {
+				log.Printf("[e] Failed to get subscription of %s for %s: %v\n", h.src.URL, username, err)
+			}
+			continue
+		}
+		if sub.Settings.Summaries {
+			res[username] = true
+		}
+	}
+	return res
+}
+
+// The entry as seen by a subscriber, without the summary unless asked for, as entries are shared.
+func entryFor(username string, summaryReaders map[string]bool, entry feed.ItemEntry) feed.ItemEntry {
+	if !summaryReaders[username] {
+		entry.Summary = ""
+	}
+	return entry
+}
+
+// Post the stored entry of a new item to the webhooks of subscribers.
+func (h *feedHandler) deliverWebhooks(subscribers []string, summaryReaders map[string]bool, entry feed.ItemEntry, link string) {
+	for _, username := range subscribers {
+		if err := webhook.DeliverItem(username, h.src, entryFor(username, summaryReaders, entry), link); err != nil {
+			log.Printf("[e] Failed to queue webhook deliveries of %s for %s: %v\n", h.src.URL, username, err)
+		}
+	}
+}
+
+// Push the stored entry of a new item to the connected clients of subscribers, who have it unread.
+func (h *feedHandler) publishNewItem(subscribers []string, summaryReaders map[string]bool, entry feed.ItemEntry) {
+	for _, username := range subscribers {
+		cnt, err := user.GetUnreadFeedCount(username, h.channelID)
+		if err != nil {
+			log.Printf("[e] Failed to get unread count of %s for %s: %v\n", h.src.URL, username, err)
+			continue
+		}
+		ev := event.NewItem{SourceID: h.channelID, Entry: entryFor(username, summaryReaders, entry), UnreadCount: cnt}
+		if err := event.PublishNewItem(username, ev); err != nil {
+			log.Printf("[e] Failed to publish new item of %s to %s: %v\n", h.src.URL, username, err)
+		}
+	}
+}
+
 func (h *feedHandler) getItemID(i *rss.Item) (res string) {
 	// Based on channel key, then concatenate the per-item ID.
 	itemID := h.channelURL
@@ -145,10 +321,27 @@ func (h *feedHandler) getItemID(i *rss.Item) (res string) {
 	return util.FormatFeedKey(fmt.Sprintf("%x", sha1.Sum([]byte(itemID))))
 }
 
+// Adapts an ordinary function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func getChannelID(key string) string {
 	return util.FormatFeedSourceKey(fmt.Sprintf("%x", sha1.Sum([]byte(key))))
 }
 
+// Use the first alternate link of the channel as the site link, skipping e.g. Atom's "self".
+func getChannelLink(ch *rss.Channel) string {
+	for _, l := range ch.Links {
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return l.Href
+		}
+	}
+	return ""
+}
+
 func getItemContent(i *rss.Item) *string {
 	if i.Content != nil {
 		if match := contentRe.FindStringSubmatch(i.Content.Text); match != nil {
@@ -0,0 +1,139 @@
+package keyword
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edfward/readkey/libstore"
+	"github.com/edfward/readkey/util"
+	"github.com/garyburd/redigo/redis"
+	"github.com/kennygrant/sanitize"
+)
+
+// Caches the keywords fetched by another fetcher in Redis, by a hash of the sanitized text and its
+// language, so that the same content syndicated by several feeds or delivered again is extracted
+// once. Concurrent fetches of the same content wait for the first one.
+type cachingFetcher struct {
+	rs      libstore.RedisStrore
+	fetcher Fetcher
+	ttl     time.Duration
+
+	mu sync.Mutex
+	// Fetches in progress by cache key.
+	inflight map[string]*fetchCall
+}
+
+type fetchCall struct {
+	done     chan struct{}
+	keywords []Keyword
+	err      error
+}
+
+type noCacheKey struct{}
+
+// NewCachingFetcher returns a keyword fetcher caching the keywords fetched by `fetcher` for `ttl`.
+// Failed fetches aren't cached.
+func NewCachingFetcher(store libstore.RedisStrore, fetcher Fetcher, ttl time.Duration) Fetcher {
+	return &cachingFetcher{
+		rs:       store,
+		fetcher:  fetcher,
+		ttl:      ttl,
+		inflight: make(map[string]*fetchCall),
+	}
+}
+
+// NoCache returns a context for fetching keywords afresh rather than from the cache, e.g. after the
+// extractor is upgraded. The cached keywords are replaced with the fetched ones.
+func NoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// Fetch of cachingFetcher returns the cached keywords of the text if any, otherwise fetches and
+// caches them.
+func (f *cachingFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	key := cacheKey(*contentPtr, lang)
+	if ctx.Value(noCacheKey{}) != nil {
+		keywords, err := f.fetcher.Fetch(ctx, contentPtr, lang)
+		if err == nil {
+			f.set(key, keywords)
+		}
+		return keywords, err
+	}
+	if keywords, ok := f.get(key); ok {
+		return keywords, nil
+	}
+
+	f.mu.Lock()
+	call, ok := f.inflight[key]
+	if !ok {
+		call = &fetchCall{done: make(chan struct{})}
+		f.inflight[key] = call
+	}
+	f.mu.Unlock()
+	if ok {
+		select {
+		case <-call.done:
+			return call.keywords, call.err
+		case <-ctx.Done():
+			return nil, UnavailableError{ctx.Err()}
+		}
+	}
+
+	call.keywords, call.err = f.fetcher.Fetch(ctx, contentPtr, lang)
+	if call.err == nil {
+		f.set(key, call.keywords)
+	}
+	f.mu.Lock()
+	delete(f.inflight, key)
+	f.mu.Unlock()
+	close(call.done)
+	return call.keywords, call.err
+}
+
+// Get the cached keywords, if any. Redis failures are taken as a miss.
+func (f *cachingFetcher) get(key string) ([]Keyword, bool) {
+	var reply []byte
+	err := f.rs.Do(func(c redis.Conn) (err error) {
+		reply, err = redis.Bytes(c.Do("GET", key))
+		return
+	})
+	if err == redis.ErrNil {
+		return nil, false
+	} else if err != nil {
+		log.Printf("[w] Failed to get cached keywords: %v\n", err)
+		return nil, false
+	}
+	var keywords []Keyword
+	if err := json.Unmarshal(reply, &keywords); err != nil {
+		log.Printf("[w] Failed to decode cached keywords: %v\n", err)
+		return nil, false
+	}
+	return keywords, true
+}
+
+func (f *cachingFetcher) set(key string, keywords []Keyword) {
+	if keywords == nil {
+		keywords = []Keyword{}
+	}
+	packet, _ := json.Marshal(keywords)
+	err := f.rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("SET", key, packet, "PX", int64(f.ttl/time.Millisecond))
+		return err
+	})
+	if err != nil {
+		log.Printf("[w] Failed to cache keywords: %v\n", err)
+	}
+}
+
+// Key of the cached keywords of an HTML text in the language, by the SHA-256 of both. Texts equal
+// but for markup share keywords, as they are extracted from the sanitized text.
+func cacheKey(content, lang string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(lang) + "\x00" + sanitize.HTML(content)))
+	return util.FormatKeywordCacheKey(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,142 @@
+package keyword
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edfward/readkey/libstore/storetest"
+)
+
+// Fetcher counting its calls, which wait for `release` if set.
+type countingFetcher struct {
+	mu       sync.Mutex
+	calls    int
+	keywords []Keyword
+	err      error
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.release != nil {
+		<-f.release
+	}
+	return f.keywords, f.err
+}
+
+func (f *countingFetcher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestCachingFetcherFetchesOnce(t *testing.T) {
+	stub := &countingFetcher{
+		keywords: []Keyword{{"golang", 0.9}},
+		started:  make(chan struct{}, 10),
+		release:  make(chan struct{}),
+	}
+	f := NewCachingFetcher(storetest.New(), stub, time.Hour)
+
+	// The same text but for markup.
+	contents := []string{"<p>Go <b>1.9</b> is out</p>", "<p>Go 1.9 is out</p>", "<p>Go <i>1.9</i> is out</p>"}
+	var wg sync.WaitGroup
+	results := make([][]Keyword, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := contents[i%len(contents)]
+			keywords, err := f.Fetch(context.Background(), &content, "en")
+			if err != nil {
+				t.Errorf("fetch %d: %v", i, err)
+			}
+			results[i] = keywords
+		}(i)
+	}
+	<-stub.started
+	// Let the others join the fetch in progress.
+	time.Sleep(50 * time.Millisecond)
+	close(stub.release)
+	wg.Wait()
+
+	for i, keywords := range results {
+		if len(keywords) != 1 || keywords[0].Text != "golang" {
+			t.Errorf("fetch %d: got %v, want the fetched keywords", i, keywords)
+		}
+	}
+	// Served from the cache afterwards.
+	content := "<p>Go 1.9 is <em>out</em></p>"
+	if keywords, err := f.Fetch(context.Background(), &content, "EN"); err != nil || len(keywords) != 1 {
+		t.Errorf("got %v, %v, want the cached keywords", keywords, err)
+	}
+	if n := stub.count(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+
+	// Not shared across languages.
+	stub.started = nil
+	if _, err := f.Fetch(context.Background(), &content, "de"); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.count(); n != 2 {
+		t.Errorf("fetched %d times, want 2 after a fetch in another language", n)
+	}
+}
+
+func TestCachingFetcherNoCache(t *testing.T) {
+	stub := &countingFetcher{keywords: []Keyword{{"old", 1}}}
+	f := NewCachingFetcher(storetest.New(), stub, time.Hour)
+	content := "text"
+
+	if _, err := f.Fetch(context.Background(), &content, "en"); err != nil {
+		t.Fatal(err)
+	}
+	stub.keywords = []Keyword{{"new", 1}}
+	keywords, err := f.Fetch(NoCache(context.Background()), &content, "en")
+	if err != nil || len(keywords) != 1 || keywords[0].Text != "new" {
+		t.Errorf("got %v, %v, want keywords fetched afresh", keywords, err)
+	}
+	if n := stub.count(); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+	// The cached keywords were replaced.
+	keywords, err = f.Fetch(context.Background(), &content, "en")
+	if err != nil || len(keywords) != 1 || keywords[0].Text != "new" {
+		t.Errorf("got %v, %v, want the keywords fetched afresh from the cache", keywords, err)
+	}
+	if n := stub.count(); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
+
+func TestCachingFetcherSkipsErrors(t *testing.T) {
+	stub := &countingFetcher{err: UnavailableError{errors.New("connection refused")}}
+	store := storetest.New()
+	f := NewCachingFetcher(store, stub, time.Hour)
+	content := "text"
+
+	if _, err := f.Fetch(context.Background(), &content, "en"); err == nil {
+		t.Fatal("succeeded, want the fetcher's error")
+	}
+	if len(store.Strings) != 0 {
+		t.Errorf("cached %v after a failed fetch", store.Strings)
+	}
+	stub.err = nil
+	stub.keywords = []Keyword{{"text", 1}}
+	if keywords, err := f.Fetch(context.Background(), &content, "en"); err != nil || len(keywords) != 1 {
+		t.Errorf("got %v, %v, want keywords fetched again", keywords, err)
+	}
+	if n := stub.count(); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,376 @@
+package keyword
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+)
+
+// Attempts of a request to the keyword server while it's unavailable, and the delay before the
+// first retry which doubles after each one.
+const (
+	maxAttempts  = 3
+	initialDelay = 500 * time.Millisecond
+)
+
+// Timeout of a request to the keyword server by default, and how long documents are collected for a
+// batch request after the first one.
+const (
+	defaultTimeout = 30 * time.Second
+	batchWait      = 100 * time.Millisecond
+)
+
+// ClientOptions configures the requests to the keyword server, which are shared by all the feeds.
+type ClientOptions struct {
+	// Requests in flight at most, 1 if not positive.
+	MaxConcurrent int
+	// Requests started per second at most, unlimited if not positive.
+	RateLimit float64
+	// Timeout of a request including reading its response, `defaultTimeout` if not positive.
+	Timeout time.Duration
+	// Documents sent in one request at most. Each document is sent on its own if at most 1.
+	BatchSize int
+}
+
+type keywordFetcher struct {
+	serverAddr string
+	// Number of keywords to extract.
+	size   int
+	client *http.Client
+	// Slots of the requests in flight.
+	sem     chan struct{}
+	limiter *limiter
+	// Documents waiting to be sent in a batch request, nil if batching is off.
+	docs      chan *document
+	batchSize int
+}
+
+// Text to extract keywords from in a batch request, and where its result goes.
+type document struct {
+	Lang  string `json:"lang"`
+	Text  string `json:"text"`
+	resCh chan batchResult
+}
+
+type batchResult struct {
+	keywords []Keyword
+	err      error
+}
+
+// NewKeywordFetcher returns a new keyword fetcher, extracting up to `size` keywords of a text. All
+// its requests go through one client with the limits of `opts`, however many feeds use it.
+func NewKeywordFetcher(serverAddr string, size int, opts ClientOptions) Fetcher {
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	f := &keywordFetcher{
+		serverAddr: serverAddr,
+		size:       size,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// Keep a connection for each request in flight, rather than the default 2.
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   5 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				MaxIdleConns:        opts.MaxConcurrent,
+				MaxIdleConnsPerHost: opts.MaxConcurrent,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		sem:     make(chan struct{}, opts.MaxConcurrent),
+		limiter: newLimiter(opts.RateLimit),
+	}
+	if opts.BatchSize > 1 {
+		f.docs = make(chan *document)
+		f.batchSize = opts.BatchSize
+		go f.batch()
+	}
+	return f
+}
+
+// Fetch of keywordFetcher fetches keywords by sending requests to the keyword server, retrying
+// while it's unavailable. In batch mode the text is sent along with others fetched meanwhile.
+func (f *keywordFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	text := sanitize.HTML(*contentPtr)
+	if f.docs != nil {
+		return f.fetchInBatch(ctx, text, lang)
+	}
+	formData := url.Values{
+		"size": {fmt.Sprint(f.size)}, // Number of keywords.
+		"lang": {lang},               // Could be empty, if so let the keyword server decide.
+		"text": {text},
+	}
+	var keywords []Keyword
+	err := retry(ctx, func() error {
+		body, err := f.post(ctx, "application/x-www-form-urlencoded", []byte(formData.Encode()))
+		if err != nil {
+			return err
+		}
+		keywords, err = parseKeywords(body)
+		return err
+	})
+	return keywords, err
+}
+
+// Hand the text over to the batching goroutine and wait for its keywords.
+func (f *keywordFetcher) fetchInBatch(ctx context.Context, text, lang string) ([]Keyword, error) {
+	doc := &document{Lang: lang, Text: text, resCh: make(chan batchResult, 1)}
+	select {
+	case f.docs <- doc:
+	case <-ctx.Done():
+		return nil, UnavailableError{ctx.Err()}
+	}
+	select {
+	case res := <-doc.resCh:
+		return res.keywords, res.err
+	case <-ctx.Done():
+		// The batch goes on without this document's caller.
+		return nil, UnavailableError{ctx.Err()}
+	}
+}
+
+// Collect documents into batches of up to `batchSize`, each sent once full or `batchWait` after its
+// first document.
+func (f *keywordFetcher) batch() {
+	for doc := range f.docs {
+		docs := []*document{doc}
+		timeout := time.After(batchWait)
+	collect:
+		for len(docs) < f.batchSize {
+			select {
+			case doc := <-f.docs:
+				docs = append(docs, doc)
+			case <-timeout:
+				break collect
+			}
+		}
+		go f.sendBatch(docs)
+	}
+}
+
+// Send the documents in one request of format { size, documents: [{ lang, text }] }, and pass each
+// its result.
+func (f *keywordFetcher) sendBatch(docs []*document) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.client.Timeout)
+	defer cancel()
+	var results []batchResult
+	reqJSON, err := json.Marshal(struct {
+		Size      int         `json:"size"`
+		Documents []*document `json:"documents"`
+	}{f.size, docs})
+	if err == nil {
+		err = retry(ctx, func() error {
+			body, err := f.post(ctx, "application/json", reqJSON)
+			if err != nil {
+				return err
+			}
+			results, err = parseBatch(body, len(docs))
+			return err
+		})
+	}
+	for i, doc := range docs {
+		if err != nil {
+			doc.resCh <- batchResult{err: err}
+		} else {
+			doc.resCh <- results[i]
+		}
+	}
+}
+
+// Call `request` until the keyword server is available, up to `maxAttempts` times, waiting between
+// attempts at least as long as it asks to when throttling.
+func retry(ctx context.Context, request func() error) error {
+	delay := initialDelay
+	for attempt := 1; ; attempt++ {
+		err := request()
+		unavailableErr, unavailable := err.(UnavailableError)
+		if !unavailable || attempt == maxAttempts {
+			return err
+		}
+		wait := delay
+		if throttled, ok := unavailableErr.Err.(throttledError); ok && throttled.retryAfter > wait {
+			wait = throttled.retryAfter
+		}
+		select {
+		case <-time.After(wait):
+			delay *= 2
+		case <-ctx.Done():
+			return UnavailableError{ctx.Err()}
+		}
+	}
+}
+
+// Post the body to the keyword server once a slot is free and the rate limit allows, and return the
+// response body.
+func (f *keywordFetcher) post(ctx context.Context, contentType string, reqBody []byte) ([]byte, error) {
+	select {
+	case f.sem <- struct{}{}:
+		defer func() { <-f.sem }()
+	case <-ctx.Done():
+		return nil, UnavailableError{ctx.Err()}
+	}
+	if err := f.limiter.wait(ctx); err != nil {
+		return nil, UnavailableError{err}
+	}
+
+	req, err := http.NewRequest("POST", f.serverAddr, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, UnavailableError{err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, UnavailableError{err}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, UnavailableError{throttledError{parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}}
+	} else if resp.StatusCode >= 500 {
+		return nil, UnavailableError{fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)}
+	} else if resp.StatusCode >= 300 {
+		// The request won't succeed by retrying.
+		return nil, fmt.Errorf("keyword server rejected request with HTTP status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// The keyword server is throttling requests, retried after `retryAfter` if it says so.
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e throttledError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %v", e.retryAfter)
+}
+
+// Parse a Retry-After header of either delay seconds or an HTTP date, zero if absent or malformed.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// Parse the response of the keyword server of format { keywords }.
+func parseKeywords(body []byte) ([]Keyword, error) {
+	var respJSON struct {
+		Keywords []json.RawMessage `json:"keywords"`
+	}
+	if err := json.Unmarshal(body, &respJSON); err != nil {
+		return nil, errors.New("malformed keyword server response: " + err.Error())
+	}
+	return rankKeywords(respJSON.Keywords)
+}
+
+// Parse the response of the keyword server to a batch request of format { results: [{ keywords }] },
+// with a result for each of the `n` documents in order. A result of format { error } fails only its
+// document.
+func parseBatch(body []byte, n int) ([]batchResult, error) {
+	var respJSON struct {
+		Results []struct {
+			Keywords []json.RawMessage `json:"keywords"`
+			Error    string            `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &respJSON); err != nil {
+		return nil, errors.New("malformed keyword server response: " + err.Error())
+	}
+	if len(respJSON.Results) != n {
+		return nil, fmt.Errorf("malformed keyword server response: %d results for %d documents", len(respJSON.Results), n)
+	}
+	res := make([]batchResult, n)
+	for i, r := range respJSON.Results {
+		if r.Error != "" {
+			res[i].err = errors.New("keyword server failed on document: " + r.Error)
+			continue
+		}
+		res[i].keywords, res[i].err = rankKeywords(r.Keywords)
+	}
+	return res, nil
+}
+
+// Keywords are either objects of format { text, score }, or plain strings most relevant first,
+// which are scored by rank from 1 down to 1/n.
+func rankKeywords(raws []json.RawMessage) ([]Keyword, error) {
+	n := len(raws)
+	res := make([]Keyword, 0, n)
+	for i, raw := range raws {
+		var kw Keyword
+		if err := json.Unmarshal(raw, &kw.Text); err == nil {
+			kw.Score = float64(n-i) / float64(n)
+		} else if err := json.Unmarshal(raw, &kw); err != nil {
+			return nil, errors.New("malformed keyword in keyword server response: " + err.Error())
+		}
+		if kw.Text = strings.TrimSpace(kw.Text); kw.Text != "" {
+			res = append(res, kw)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+	return res, nil
+}
+
+// Spaces out the start of requests to at most a rate per second, without bursts.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// When the next request may start.
+	next time.Time
+}
+
+// Return nil, which never waits, if the rate is unlimited.
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Wait until a request may start or `ctx` is done. The turn is taken even if given up on.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package keyword
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	if l := newLimiter(0); l != nil || l.wait(context.Background()) != nil {
+		t.Errorf("newLimiter(0) = %v, want an unlimited nil limiter", l)
+	}
+
+	l := newLimiter(20)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request starts right away, the others 50ms apart.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 requests started within %v, want at least 150ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("got %v waiting with a canceled context, want %v", err, context.Canceled)
+	}
+}
+
+func TestFetchConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if inFlight++; inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte(`{"keywords": ["go"]}`))
+	}))
+	defer srv.Close()
+
+	f := NewKeywordFetcher(srv.URL, 5, ClientOptions{MaxConcurrent: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := "text"
+			if _, err := f.Fetch(context.Background(), &content, ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most > 2 {
+		t.Errorf("%d requests in flight at once, want at most 2", most)
+	}
+}
+
+func TestFetchCanceledWaitingForSlot(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		started <- true
+		<-release
+		w.Write([]byte(`{"keywords": ["go"]}`))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	f := NewKeywordFetcher(srv.URL, 5, ClientOptions{MaxConcurrent: 1})
+	go func() {
+		content := "first"
+		f.Fetch(context.Background(), &content, "")
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	content := "second"
+	start := time.Now()
+	_, err := f.Fetch(ctx, &content, "")
+	if _, unavailable := err.(UnavailableError); !unavailable {
+		t.Errorf("got %T %v, want UnavailableError", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want about the context's timeout", elapsed)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests sent, want 1", requests)
+	}
+}
+
+// Serve batch requests, answering each document with its text as keyword, or an error for "bad".
+func batchServer(t *testing.T, sizes chan<- int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got content type %q, want JSON", r.Header.Get("Content-Type"))
+		}
+		var req struct {
+			Size      int `json:"size"`
+			Documents []struct {
+				Lang string `json:"lang"`
+				Text string `json:"text"`
+			} `json:"documents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		sizes <- len(req.Documents)
+		results := make([]map[string]interface{}, len(req.Documents))
+		for i, doc := range req.Documents {
+			if text := strings.TrimSpace(doc.Text); text == "bad" {
+				results[i] = map[string]interface{}{"error": "cannot parse"}
+			} else {
+				results[i] = map[string]interface{}{"keywords": []string{text}}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+}
+
+func TestFetchBatches(t *testing.T) {
+	sizes := make(chan int, 10)
+	srv := batchServer(t, sizes)
+	defer srv.Close()
+
+	f := NewKeywordFetcher(srv.URL, 5, ClientOptions{MaxConcurrent: 4, BatchSize: 3})
+	texts := []string{"a", "b", "c", "d", "e", "f", "g"}
+	var wg sync.WaitGroup
+	for _, text := range texts {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			content := text
+			keywords, err := f.Fetch(context.Background(), &content, "en")
+			if err != nil {
+				t.Errorf("%s: %v", text, err)
+			} else if len(keywords) != 1 || keywords[0].Text != text {
+				t.Errorf("%s: got %v, want its own keywords", text, keywords)
+			}
+		}(text)
+	}
+	wg.Wait()
+	close(sizes)
+
+	total, requests := 0, 0
+	for size := range sizes {
+		if size > 3 {
+			t.Errorf("batch of %d documents, want at most 3", size)
+		}
+		total += size
+		requests++
+	}
+	if total != len(texts) || requests < 3 {
+		t.Errorf("%d documents in %d requests, want %d in at least 3", total, requests, len(texts))
+	}
+}
+
+func TestFetchBatchPartialFailure(t *testing.T) {
+	sizes := make(chan int, 10)
+	srv := batchServer(t, sizes)
+	defer srv.Close()
+
+	f := NewKeywordFetcher(srv.URL, 5, ClientOptions{BatchSize: 2})
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, text := range []string{"good", "bad"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			content := text
+			_, err := f.Fetch(context.Background(), &content, "")
+			mu.Lock()
+			errs[text] = err
+			mu.Unlock()
+		}(text)
+	}
+	wg.Wait()
+
+	if errs["good"] != nil {
+		t.Errorf("good document failed: %v", errs["good"])
+	}
+	if err := errs["bad"]; err == nil {
+		t.Error("bad document succeeded, want an error")
+	} else if _, unavailable := err.(UnavailableError); unavailable {
+		t.Errorf("bad document got %v, want an error other than unavailable", err)
+	}
+	if size := <-sizes; size != 2 {
+		t.Errorf("batch of %d documents, want both in one", size)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	res, err := parseBatch([]byte(`{"results": [{"keywords": ["b", "a"]}, {"error": "oops"}, {"keywords": []}]}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Keyword{{"b", 1}, {"a", 0.5}}; res[0].err != nil || !reflect.DeepEqual(res[0].keywords, want) {
+		t.Errorf("result 0: got %v, %v, want %v", res[0].keywords, res[0].err, want)
+	}
+	if res[1].err == nil {
+		t.Error("result 1: succeeded, want its error")
+	}
+	if res[2].err != nil || len(res[2].keywords) != 0 {
+		t.Errorf("result 2: got %v, %v, want no keywords", res[2].keywords, res[2].err)
+	}
+
+	// A malformed keyword fails only its document.
+	res, err = parseBatch([]byte(`{"results": [{"keywords": [1]}, {}]}`), 2)
+	if err != nil || res[0].err == nil || res[1].err != nil {
+		t.Errorf("got %v, %v, want the first document failed only", res, err)
+	}
+
+	for _, body := range []string{`{"results": [{"keywords": []}]}`, `not json`, `{"results": {}}`} {
+		if _, err := parseBatch([]byte(body), 2); err == nil {
+			t.Errorf("parseBatch(%s) succeeded, want an error", body)
+		}
+	}
+}
+
+func TestFetchThrottled(t *testing.T) {
+	var attempts []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"keywords": ["go"]}`))
+	}))
+	defer srv.Close()
+
+	content := "text"
+	keywords, err := NewKeywordFetcher(srv.URL, 5, ClientOptions{}).Fetch(context.Background(), &content, "")
+	if err != nil || len(keywords) != 1 {
+		t.Fatalf("got %v, %v, want the keywords of the retry", keywords, err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("%d attempts, want 2", len(attempts))
+	}
+	// Longer than the initial delay.
+	if d := attempts[1].Sub(attempts[0]); d < time.Second {
+		t.Errorf("retried after %v, want at least the 1s of Retry-After", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{" 120 ", 2 * time.Minute},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"", 0},
+		{"-1", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package keyword
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Time the keyword server is left alone after being found unavailable.
+const unavailablePause = time.Minute
+
+// Extracts keywords with the primary fetcher, e.g. of the keyword server, or with the fallback
+// while the primary is unavailable.
+type fallbackFetcher struct {
+	primary  Fetcher
+	fallback Fetcher
+
+	lock sync.Mutex
+	// Until when the primary is skipped, after it was found unavailable.
+	pausedUntil time.Time
+	// Why the primary was found unavailable last.
+	lastErr error
+}
+
+// FallbackError is returned along with the keywords of the fallback fetcher, when the primary one was
+// unavailable, so that they can be extracted again by the primary one later on.
+type FallbackError struct {
+	// Why the primary fetcher was unavailable.
+	Err error
+}
+
+func (e FallbackError) Error() string {
+	return "keywords extracted by fallback: " + e.Err.Error()
+}
+
+// NewFallbackFetcher returns a keyword fetcher using `primary`, or `fallback` while the former is
+// unavailable. Once unavailable, `primary` is only tried again after a minute.
+func NewFallbackFetcher(primary, fallback Fetcher) Fetcher {
+	return &fallbackFetcher{primary: primary, fallback: fallback}
+}
+
+// Fetch of fallbackFetcher fetches keywords with the primary fetcher unless it is paused, then the
+// fallback fetcher if it fails being unavailable, returning FallbackError with its keywords.
+func (f *fallbackFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	f.lock.Lock()
+	paused, primaryErr := time.Now().Before(f.pausedUntil), f.lastErr
+	f.lock.Unlock()
+	if !paused {
+		keywords, err := f.primary.Fetch(ctx, contentPtr, lang)
+		if _, unavailable := err.(UnavailableError); !unavailable {
+			return keywords, err
+		}
+		primaryErr = err
+		f.lock.Lock()
+		// The caller giving up, e.g. while waiting for a free slot, doesn't tell the primary is down.
+		if ctx.Err() == nil && time.Now().After(f.pausedUntil) {
+			log.Printf("[w] Extracting keywords locally for %v: %v\n", unavailablePause, err)
+			f.pausedUntil = time.Now().Add(unavailablePause)
+			f.lastErr = err
+		}
+		f.lock.Unlock()
+	}
+	// The primary fetcher may have used up the time, yet the fallback is meant to be quick.
+	keywords, err := f.fallback.Fetch(context.Background(), contentPtr, lang)
+	if err != nil {
+		return nil, err
+	}
+	return keywords, FallbackError{primaryErr}
+}
@@ -0,0 +1,79 @@
+package keyword
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type stubFetcher struct {
+	keywords []Keyword
+	err      error
+	calls    int
+}
+
+func (f *stubFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	f.calls++
+	return f.keywords, f.err
+}
+
+func TestFallbackFetcherReportsFallback(t *testing.T) {
+	primary := &stubFetcher{err: UnavailableError{errors.New("connection refused")}}
+	fallback := &stubFetcher{keywords: []Keyword{{"local", 1}}}
+	f := NewFallbackFetcher(primary, fallback)
+
+	content := "text"
+	for i := 0; i < 2; i++ {
+		keywords, err := f.Fetch(context.Background(), &content, "en")
+		if _, fellBack := err.(FallbackError); !fellBack {
+			t.Fatalf("fetch %d: got error %v, want FallbackError", i, err)
+		}
+		if len(keywords) != 1 || keywords[0].Text != "local" {
+			t.Errorf("fetch %d: got %v, want the fallback's keywords", i, keywords)
+		}
+	}
+	// Paused after the first fetch.
+	if primary.calls != 1 {
+		t.Errorf("primary fetched %d times, want 1", primary.calls)
+	}
+}
+
+func TestFallbackFetcherPassesPrimary(t *testing.T) {
+	primary := &stubFetcher{keywords: []Keyword{{"server", 1}}}
+	fallback := &stubFetcher{}
+	f := NewFallbackFetcher(primary, fallback)
+
+	content := "text"
+	keywords, err := f.Fetch(context.Background(), &content, "en")
+	if err != nil || len(keywords) != 1 || keywords[0].Text != "server" {
+		t.Errorf("got %v, %v, want the primary's keywords", keywords, err)
+	}
+
+	primary.err = errors.New("bad request")
+	if _, err := f.Fetch(context.Background(), &content, "en"); err != primary.err {
+		t.Errorf("got error %v, want the primary's", err)
+	}
+	if fallback.calls != 0 {
+		t.Errorf("fallback fetched %d times, want 0", fallback.calls)
+	}
+}
+
+func TestFallbackFetcherIgnoresCallerDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary := &stubFetcher{err: UnavailableError{ctx.Err()}}
+	fallback := &stubFetcher{keywords: []Keyword{{"local", 1}}}
+	f := NewFallbackFetcher(primary, fallback)
+
+	content := "text"
+	if _, err := f.Fetch(ctx, &content, "en"); err == nil {
+		t.Fatal("succeeded, want FallbackError")
+	}
+	primary.err = nil
+	if _, err := f.Fetch(context.Background(), &content, "en"); err != nil {
+		t.Errorf("got %v, want the primary not paused", err)
+	}
+	if primary.calls != 2 {
+		t.Errorf("primary fetched %d times, want 2", primary.calls)
+	}
+}
@@ -1,33 +1,58 @@
 package keyword
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-	"net/url"
+	"context"
 	"strings"
 
 	"github.com/kennygrant/sanitize"
 )
 
+// Keyword extracted from a text, with its relevance score. Higher scores are more relevant; they
+// are only comparable among the keywords of the same text. Zero if unknown.
+type Keyword struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score,omitempty"`
+}
+
+// UnavailableError is returned when the keyword server can't be reached, fails or times out, as
+// opposed to a text without keywords, for which an empty list is returned.
+type UnavailableError struct {
+	Err error
+}
+
+func (e UnavailableError) Error() string {
+	return "keyword server unavailable: " + e.Err.Error()
+}
+
 // Fetcher is the interface for keyword fetcher.
 type Fetcher interface {
-	Fetch(contentPtr *string, lang string, retry int) <-chan string
+	// Fetch extracts the keywords of an HTML text in the language, which could be empty if unknown,
+	// most relevant first. It returns once done, failed or `ctx` is done.
+	Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error)
 }
 
-type keywordFetcher struct {
-	serverAddr string
+// Join the keywords' text with commas, as kept in feed item entries.
+func Join(keywords []Keyword) string {
+	texts := make([]string, 0, len(keywords))
+	for _, kw := range keywords {
+		texts = append(texts, kw.Text)
+	}
+	return strings.Join(texts, ",")
 }
 
-// A special dummy fetcher, mostly for testing.
-type summaryFetcher struct {
+// Split comma-joined keywords, which have no scores.
+func Split(s string) []Keyword {
+	res := []Keyword{}
+	for _, text := range strings.Split(s, ",") {
+		if text = strings.TrimSpace(text); text != "" {
+			res = append(res, Keyword{Text: text})
+		}
+	}
+	return res
 }
 
-// NewKeywordFetcher returns a new keyword fetcher.
-func NewKeywordFetcher(serverAddr string) Fetcher {
-	return &keywordFetcher{
-		serverAddr: serverAddr,
-	}
+// A special dummy fetcher, mostly for testing.
+type summaryFetcher struct {
 }
 
 // NewSummaryFetcher returns a dummy summary fetcher.
@@ -35,56 +60,20 @@ func NewSummaryFetcher() Fetcher {
 	return &summaryFetcher{}
 }
 
-// Fetch of summaryFetcher simply retrieves a small part of the content, mostly for testing.
-func (f *summaryFetcher) Fetch(contentPtr *string, lang string, retry int) <-chan string {
-	// `retry` and `lang` are redundant for summary extraction.
-	resCh := make(chan string, 1)
+// Fetch of summaryFetcher simply retrieves a small part of the content as the only keyword, mostly
+// for testing.
+func (f *summaryFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	// `lang` is redundant for summary extraction.
 	const summarySize = 30
-	go func() {
-		summary := sanitize.HTML(*contentPtr)
-		// Handle Unicode.
-		r := []rune(summary)
-		// Truncate if too many.
-		if len(r) > summarySize {
-			r = r[:summarySize]
-		}
-		resCh <- string(r)
-	}()
-	return resCh
-}
-
-// Fetch of keywordFetcher fetches keywords by sending requests to the keyword server.
-func (f *keywordFetcher) Fetch(contentPtr *string, lang string, retry int) <-chan string {
-	resCh := make(chan string, 1)
-	go func() {
-		formData := url.Values{
-			"size": {"5"},  // Number of keywords.
-			"lang": {lang}, // Could be empty, if so let the keyword server decide.
-			"text": {sanitize.HTML(*contentPtr)},
-		}
-		for i := 0; i < retry; i++ {
-			resp, err := http.PostForm(f.serverAddr, formData)
-			if err != nil {
-				continue
-			}
-
-			// Successful request.
-			defer resp.Body.Close()
-			body, _ := ioutil.ReadAll(resp.Body)
-
-			var respJSON struct {
-				Keywords []string `json:"keywords"`
-			}
-
-			if err := json.Unmarshal(body, &respJSON); err != nil {
-				// TODO: Ignore if parsing json fails.
-			} else {
-				resCh <- strings.Join(respJSON.Keywords, ",")
-			}
-
-			// Exit the loop when successful.
-			break
-		}
-	}()
-	return resCh
+	summary := sanitize.HTML(*contentPtr)
+	// Handle Unicode.
+	r := []rune(summary)
+	// Truncate if too many.
+	if len(r) > summarySize {
+		r = r[:summarySize]
+	}
+	if len(r) == 0 {
+		return []Keyword{}, nil
+	}
+	return []Keyword{{string(r), 1}}, nil
 }
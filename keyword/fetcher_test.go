@@ -0,0 +1,149 @@
+package keyword
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeywords(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Keyword
+	}{
+		{"ranks plain strings", `{"keywords": ["go", "generics", "types"]}`,
+			[]Keyword{{"go", 1}, {"generics", 2.0 / 3}, {"types", 1.0 / 3}}},
+		{"sorts scored objects", `{"keywords": [{"text": "b", "score": 0.2}, {"text": "a", "score": 0.9}]}`,
+			[]Keyword{{"a", 0.9}, {"b", 0.2}}},
+		{"keeps objects without score", `{"keywords": [{"text": "a"}]}`, []Keyword{{"a", 0}}},
+		{"trims and drops blanks", `{"keywords": [" go ", "  ", ""]}`, []Keyword{{"go", 1}}},
+		{"keeps commas within keywords", `{"keywords": ["a, b"]}`, []Keyword{{"a, b", 1}}},
+		{"empty list", `{"keywords": []}`, []Keyword{}},
+		{"missing list", `{}`, []Keyword{}},
+	}
+	for _, tt := range tests {
+		got, err := parseKeywords([]byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeywordsMalformed(t *testing.T) {
+	for _, body := range []string{`not json`, `{"keywords": "go"}`, `{"keywords": [1]}`, `{"keywords": [{"text": 1}]}`} {
+		if _, err := parseKeywords([]byte(body)); err == nil {
+			t.Errorf("parseKeywords(%s) succeeded, want an error", body)
+		} else if _, unavailable := err.(UnavailableError); unavailable {
+			t.Errorf("parseKeywords(%s) = %v, want an error other than unavailable", body, err)
+		}
+	}
+}
+
+func TestFetchSendsForm(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("size") != "3" || r.Form.Get("lang") != "en" || strings.TrimSpace(r.Form.Get("text")) != "Hello world" {
+			t.Errorf("unexpected form %v", r.Form)
+		}
+		w.Write([]byte(`{"keywords": ["hello"]}`))
+	}))
+	defer srv.Close()
+
+	content := "<p>Hello <b>world</b></p>"
+	keywords, err := NewKeywordFetcher(srv.URL, 3, ClientOptions{}).Fetch(context.Background(), &content, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Keyword{{"hello", 1}}; !reflect.DeepEqual(keywords, want) {
+		t.Errorf("got %v, want %v", keywords, want)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		unavailable bool
+		attempts    int
+	}{
+		{"server error is retried", 500, ``, true, maxAttempts},
+		{"bad gateway is retried", 502, ``, true, maxAttempts},
+		{"rejection isn't retried", 400, ``, false, 1},
+		{"malformed response isn't retried", 200, `oops`, false, 1},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		content := "text"
+		_, err := NewKeywordFetcher(srv.URL, 5, ClientOptions{}).Fetch(context.Background(), &content, "")
+		srv.Close()
+
+		if err == nil {
+			t.Errorf("%s: succeeded, want an error", tt.name)
+			continue
+		}
+		if _, unavailable := err.(UnavailableError); unavailable != tt.unavailable {
+			t.Errorf("%s: got %T %v, want unavailable %v", tt.name, err, err, tt.unavailable)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, attempts, tt.attempts)
+		}
+	}
+}
+
+func TestFetchConnectionRefused(t *testing.T) {
+	// Find a port nothing listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "http://" + l.Addr().String()
+	l.Close()
+
+	content := "text"
+	_, err = NewKeywordFetcher(addr, 5, ClientOptions{}).Fetch(context.Background(), &content, "")
+	if _, unavailable := err.(UnavailableError); !unavailable {
+		t.Errorf("got %T %v, want UnavailableError", err, err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	content := "text"
+	_, err := NewKeywordFetcher(srv.URL, 5, ClientOptions{}).Fetch(ctx, &content, "")
+	if _, unavailable := err.(UnavailableError); !unavailable {
+		t.Errorf("got %T %v, want UnavailableError", err, err)
+	}
+}
+
+func TestJoinSplit(t *testing.T) {
+	keywords := []Keyword{{"go", 1}, {"generics", 0.5}}
+	if got := Join(keywords); got != "go,generics" {
+		t.Errorf("Join = %q", got)
+	}
+	if got, want := Split(" go, ,generics "), []Keyword{{Text: "go"}, {Text: "generics"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package keyword
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kennygrant/sanitize"
+)
+
+// Words in a keyword phrase at most.
+const maxPhraseWords = 3
+
+// Extracts keywords in process with RAKE (Rapid Automatic Keyword Extraction): phrases are the runs
+// of words between stopwords and punctuation, scored by the sum of their words' degree to frequency
+// ratio, which favors words occurring in longer phrases.
+type localFetcher struct {
+	// Number of keywords to extract.
+	size int
+}
+
+// NewLocalFetcher returns a keyword fetcher extracting up to `size` keywords of a text in process,
+// without the keyword server.
+func NewLocalFetcher(size int) Fetcher {
+	return &localFetcher{size: size}
+}
+
+// Candidate keyword phrase.
+type phrase struct {
+	// Words in lower case.
+	words []string
+	// Text as first seen.
+	text  string
+	count int
+}
+
+// Fetch of localFetcher extracts keywords right away, with the stopwords of the language.
+func (f *localFetcher) Fetch(ctx context.Context, contentPtr *string, lang string) ([]Keyword, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	phrases := candidatePhrases(sanitize.HTML(*contentPtr), StopwordsOf(lang))
+
+	// Frequency and degree of each word, i.e. the number of words in the phrases it occurs in.
+	freq := make(map[string]int)
+	degree := make(map[string]int)
+	for _, p := range phrases {
+		for _, w := range p.words {
+			freq[w] += p.count
+			degree[w] += len(p.words) * p.count
+		}
+	}
+
+	type scored struct {
+		Keyword
+		count int
+	}
+	ranked := make([]scored, 0, len(phrases))
+	for _, p := range phrases {
+		var score float64
+		for _, w := range p.words {
+			score += float64(degree[w]) / float64(freq[w])
+		}
+		ranked = append(ranked, scored{Keyword{p.text, score}, p.count})
+	}
+	// Highest scores first, then the most frequent ones.
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].count > ranked[j].count
+	})
+	res := make([]Keyword, 0, f.size)
+	for i := 0; i < len(ranked) && i < f.size; i++ {
+		res = append(res, ranked[i].Keyword)
+	}
+	// Scale scores to at most 1.
+	if len(res) > 0 {
+		top := res[0].Score
+		for i := range res {
+			res[i].Score /= top
+		}
+	}
+	return res, nil
+}
+
+// Split the text into candidate phrases, in order of their first occurrence.
+func candidatePhrases(text string, stopwords map[string]bool) []*phrase {
+	var res []*phrase
+	byKey := make(map[string]*phrase)
+	var words, surface []string
+	flush := func() {
+		// Overlong runs are unlikely keywords, e.g. when no stopword list suits the language.
+		if len(words) > 0 && len(words) <= maxPhraseWords {
+			key := strings.Join(words, " ")
+			if p, ok := byKey[key]; ok {
+				p.count++
+			} else {
+				p = &phrase{words: words, text: strings.Join(surface, " "), count: 1}
+				byKey[key] = p
+				res = append(res, p)
+			}
+		}
+		words, surface = nil, nil
+	}
+
+	for _, token := range tokenize(text) {
+		if token == "" {
+			// Punctuation.
+			flush()
+			continue
+		}
+		lower := strings.Replace(strings.ToLower(token), "’", "'", -1)
+		// Elided articles and pronouns, e.g. "l'" in French.
+		if i := strings.Index(lower, "'"); i > 0 && stopwords[lower[:i+1]] {
+			lower = lower[i+1:]
+			// Lower casing keeps the number of runes in the remaining letters.
+			r := []rune(token)
+			token = string(r[len(r)-len([]rune(lower)):])
+		}
+		if stopwords[lower] || len([]rune(lower)) < 2 || isNumber(lower) {
+			flush()
+			continue
+		}
+		words = append(words, lower)
+		surface = append(surface, token)
+	}
+	flush()
+	return res
+}
+
+// Scripts written without spaces between words, which can't be split into words here.
+var unspacedScripts = []*unicode.RangeTable{
+	unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar,
+}
+
+// IsUnspaced reports whether the rune is of a script written without spaces between words, e.g.
+// Chinese or Thai.
+func IsUnspaced(r rune) bool {
+	return unicode.In(r, unspacedScripts...)
+}
+
+// Split the text into words, with an empty token for each run of punctuation separating phrases.
+// Apostrophes and hyphens within words are kept. Text of scripts without word spacing is taken as
+// punctuation, as it would come out as whole clauses, so such texts get no keywords.
+func tokenize(text string) []string {
+	var res []string
+	runes := []rune(text)
+	start := -1
+	for i, r := range runes {
+		inWord := (unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)) &&
+			!IsUnspaced(r)
+		if !inWord && (r == '\'' || r == '’' || r == '-') && start >= 0 &&
+			i+1 < len(runes) && unicode.IsLetter(runes[i+1]) {
+			inWord = true
+		}
+		if inWord {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			res = append(res, string(runes[start:i]))
+			start = -1
+		}
+		if !unicode.IsSpace(r) && (len(res) == 0 || res[len(res)-1] != "") {
+			res = append(res, "")
+		}
+	}
+	if start >= 0 {
+		res = append(res, string(runes[start:]))
+	}
+	return res
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsNumber(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package keyword
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalFetcher(t *testing.T) {
+	content := `<p>Compiler optimizations make Go programs faster. The Go compiler inlines small
+		functions, and compiler optimizations such as escape analysis keep values on the stack.</p>`
+	keywords, err := NewLocalFetcher(3).Fetch(context.Background(), &content, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keywords) == 0 || len(keywords) > 3 {
+		t.Fatalf("got %v, want 1 to 3 keywords", keywords)
+	}
+	if keywords[0].Score != 1 {
+		t.Errorf("top score %v, want 1", keywords[0].Score)
+	}
+	for i := 1; i < len(keywords); i++ {
+		if keywords[i].Score > keywords[i-1].Score {
+			t.Errorf("keywords not sorted by score: %v", keywords)
+		}
+	}
+}
+
+func TestLocalFetcherUnspacedScripts(t *testing.T) {
+	for _, content := range []string{
+		"東京で新しい技術展示会が開催されました。多くの企業が参加しました。",
+		"北京今天发布了新的经济数据，显示增长放缓。",
+		"กรุงเทพมหานครเป็นเมืองหลวงของประเทศไทย",
+	} {
+		keywords, err := NewLocalFetcher(5).Fetch(context.Background(), &content, "")
+		if err != nil {
+			t.Errorf("%s: %v", content, err)
+		} else if len(keywords) != 0 {
+			t.Errorf("%s: got %v, want no keywords", content, keywords)
+		}
+	}
+}
+
+func TestLocalFetcherMixedScripts(t *testing.T) {
+	content := "Kubernetes クラスタ の 設定"
+	keywords, err := NewLocalFetcher(5).Fetch(context.Background(), &content, "ja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keywords) != 1 || keywords[0].Text != "Kubernetes" {
+		t.Errorf("got %v, want only the Latin word", keywords)
+	}
+}
+
+func TestTokenizeElision(t *testing.T) {
+	content := "L'économie d'Europe"
+	keywords, _ := NewLocalFetcher(5).Fetch(context.Background(), &content, "fr")
+	for _, kw := range keywords {
+		if kw.Text == "L'économie" {
+			t.Errorf("elided article kept in %v", keywords)
+		}
+	}
+}
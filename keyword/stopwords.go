@@ -0,0 +1,97 @@
+package keyword
+
+import (
+	"sort"
+	"strings"
+)
+
+// Stopwords of common languages by ISO 639-1 code, which separate keyword phrases.
+var stopwords = map[string]map[string]bool{
+	"en": wordSet(`a about above after again against all almost also although always am among an and
+		another any anyone anything are aren't around as at back be became because become been before
+		being below between both but by can can't cannot could couldn't did didn't do does doesn't doing
+		don't done down during each either else enough even ever every few first for from further get
+		gets getting got had hadn't has hasn't have haven't having he he'd he'll he's her here here's
+		hers herself him himself his how how's however i i'd i'll i'm i've if in into is isn't it it's
+		its itself just last least less let's like made make makes many may me might more most much must
+		mustn't my myself need never new next no nor not nothing now of off often on once one only or
+		other others ought our ours ourselves out over own per perhaps quite rather really said same say
+		says see seen several shall shan't she she'd she'll she's should shouldn't since so some
+		something still such than that that's the their theirs them themselves then there there's
+		therefore these they they'd they'll they're they've thing things this those though through thus
+		to too toward towards two under until up upon us use used using very via was wasn't way we we'd
+		we'll we're we've well were weren't what what's whatever when when's where where's whether which
+		while who who's whom whose why why's will with within without won't would wouldn't yet you you'd
+		you'll you're you've your yours yourself yourselves`),
+	"de": wordSet(`aber alle allem allen aller alles als also am an ander andere anderem anderen anderer
+		anderes auch auf aus bei beim bin bis bist da damit dann das dass dasselbe dazu dein deine deinem
+		deinen deiner dem den denn der derer des desselben dessen dich die dies diese dieselbe dieselben
+		diesem diesen dieser dieses dir doch dort du durch ein eine einem einen einer eines einig einige
+		einigem einigen einiger einiges einmal er es etwas euch euer eure eurem euren eurer für gegen
+		gewesen hab habe haben hat hatte hatten hier hin hinter ich ihm ihn ihnen ihr ihre ihrem ihren
+		ihrer ihres im in indem ins ist jede jedem jeden jeder jedes jene jenem jenen jener jenes jetzt
+		kann kein keine keinem keinen keiner kann können könnte machen man manche manchem manchen mancher
+		manches mein meine meinem meinen meiner mich mir mit muss musste nach nicht nichts noch nun nur
+		ob oder ohne sehr sein seine seinem seinen seiner seit sich sie sind so solche solchem solchen
+		solcher sollte sondern sonst über um und uns unser unsere unter viel vom von vor wann war waren
+		warum was weg weil weiter welche welchem welchen welcher welches wenn werde werden wie wieder
+		will wir wird wo wollen wollte würde würden zu zum zur zwar zwischen`),
+	"fr": wordSet(`à afin ai aie aient ainsi alors as au aucun aussi autre aux avaient avais avait avec
+		avez avoir avons ayant c' ce ceci cela celle celles celui cependant ces cet cette ceux chaque
+		chez comme comment d' dans de des donc dont du elle elles en encore entre es est et étaient
+		étais était été être eu eux fait fois font hors il ils j' je jusqu' l' la là le les leur leurs
+		lui m' ma mais me même mes moi mon n' ne ni nos notre nous on ont or ou où par parce pas peu peut
+		plus pour pourquoi qu' quand que quel quelle quelles quels qui quoi s' sa sans se selon ses si
+		son sont sous sur ta te tes toi ton tous tout toute toutes très tu un une vos votre vous y`),
+	"es": wordSet(`a al algo algunas algunos ante antes como con contra cual cuando de del desde donde
+		durante e el ella ellas ello ellos en entre era eran es esa esas ese eso esos esta estaba estado
+		estan estar estas este esto estos fue fueron ha habia han hasta hay la las le les lo los mas me
+		mi mis mucho muy nada ni no nos nosotros o otra otras otro otros para pero poco por porque que
+		quien se sea segun ser si sido sin sobre son su sus tambien tanto te tiene tienen todo todos tu
+		tus un una uno unos y ya yo más también está están según sí qué él`),
+	"it": wordSet(`a ad agli ai al alla alle allo anche che chi ci come con contro cui da dal dalla dalle
+		degli dei del della delle dello di dove e è ed era erano essere gli ha hanno ho i il in io la le
+		lei li lo loro lui ma mi mia mio ne negli nei nel nella nelle no noi non nostro o ogni per perché
+		più poi quale quando quella quelle quello questa queste questo se sei si sia siamo sono su sua
+		sue sui sul sulla suo tra tu tutti tutto un una uno vi voi`),
+	"pt": wordSet(`a ao aos as até com como da das de dela dele deles do dos e é ela elas ele eles em
+		entre era eram essa esse esta está este eu foi foram há isso isto já la lhe mais mas me mesmo
+		meu minha muito na nas não nem no nos nós o os ou para pela pelas pelo pelos por qual quando que
+		quem se sem ser seu seus só sua suas também te tem um uma umas uns você`),
+	"nl": wordSet(`aan al alles als altijd andere ben bij daar dan dat de der deze die dit doch doen door
+		dus een eens en er ge geen geweest haar had heb hebben heeft hem het hier hij hoe hun iemand iets
+		ik in is ja je kan kon kunnen maar me meer men met mij mijn moet na naar niet niets nog nu of om
+		omdat onder ons ook op over reeds te tegen toch toen tot u uit uw van veel voor want waren was
+		wat we wel werd wezen wie wij wil worden zal ze zelf zich zij zijn zo zonder zou`),
+}
+
+// StopwordsOf returns the set of stopwords of a language tag, e.g. "en-US", English ones if unknown.
+// The set is shared and must not be modified.
+func StopwordsOf(lang string) map[string]bool {
+	lang = strings.ToLower(lang)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if words, ok := stopwords[lang]; ok {
+		return words
+	}
+	return stopwords["en"]
+}
+
+// Stopwords returns the stopwords of a language by ISO 639-1 code, e.g. "en", nil if unknown.
+func Stopwords(lang string) []string {
+	var res []string
+	for w := range stopwords[lang] {
+		res = append(res, w)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func wordSet(words string) map[string]bool {
+	res := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		res[w] = true
+	}
+	return res
+}
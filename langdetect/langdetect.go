@@ -0,0 +1,148 @@
+// Package langdetect guesses the language of a text offline, for feed items whose feed doesn't say.
+// Texts in a script mostly used by one language are told by their script, and those in the Latin
+// script by the likelihood of their character trigrams under the profile of each known language,
+// learnt from sample texts at startup.
+package langdetect
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/edfward/readkey/keyword"
+)
+
+// Trigrams a text needs at least to be told apart among the Latin script languages, and the margin
+// of the log-likelihood per trigram between the most likely language and the next one.
+const (
+	minTrigrams = 60
+	minMargin   = 0.1
+)
+
+// Texts of more letters are cut short, which is plenty to tell the language.
+const maxLetters = 2000
+
+// Trigram frequencies of a language.
+type profile struct {
+	lang   string
+	counts map[string]int
+	total  int
+}
+
+var profiles []*profile
+
+// Distinct trigrams in all the profiles, for smoothing.
+var vocabulary int
+
+func init() {
+	seen := make(map[string]bool)
+	for lang, sample := range samples {
+		p := &profile{lang: lang, counts: make(map[string]int)}
+		// Stopwords are the most common words, which the samples are too short to reflect.
+		text := sample + " " + strings.Join(keyword.Stopwords(lang), " ")
+		for _, g := range trigrams(text) {
+			p.counts[g]++
+			p.total++
+			seen[g] = true
+		}
+		profiles = append(profiles, p)
+	}
+	vocabulary = len(seen)
+}
+
+// Detect returns the ISO 639-1 code of the language of a plain text, e.g. "en", or an empty string
+// if unsure, e.g. of a text too short or in an unknown language.
+func Detect(text string) string {
+	if lang := detectScript(text); lang != "" {
+		return lang
+	}
+	grams := trigrams(text)
+	if len(grams) < minTrigrams {
+		return ""
+	}
+
+	best, second := math.Inf(-1), math.Inf(-1)
+	lang := ""
+	for _, p := range profiles {
+		var score float64
+		for _, g := range grams {
+			score += math.Log(float64(p.counts[g]+1) / float64(p.total+vocabulary))
+		}
+		if score > best {
+			best, second, lang = score, best, p.lang
+		} else if score > second {
+			second = score
+		}
+	}
+	if (best-second)/float64(len(grams)) < minMargin {
+		return ""
+	}
+	return lang
+}
+
+// Language of the script most letters of the text are in, if other than Latin and used by mostly
+// one language. Japanese is told from Chinese by its kana.
+func detectScript(text string) string {
+	scripts := []struct {
+		lang  string
+		table *unicode.RangeTable
+	}{
+		{"ja", unicode.Hiragana},
+		{"ja", unicode.Katakana},
+		{"ko", unicode.Hangul},
+		{"zh", unicode.Han},
+		{"ru", unicode.Cyrillic},
+		{"el", unicode.Greek},
+		{"ar", unicode.Arabic},
+		{"he", unicode.Hebrew},
+		{"th", unicode.Thai},
+		{"hi", unicode.Devanagari},
+	}
+	counts := make(map[string]int)
+	letters := 0
+	for i, r := range text {
+		if i >= maxLetters*4 {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	// Kanji are shared with Chinese, so any kana tells Japanese.
+	if counts["ja"] > 0 && counts["ja"]+counts["zh"] > letters/2 {
+		return "ja"
+	}
+	for lang, n := range counts {
+		if n > letters/2 {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Character trigrams of the words of the text in lower case, each word padded with spaces, and the
+// words themselves, which tell languages apart by their function words.
+func trigrams(text string) []string {
+	var res []string
+	letters := 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			res = append(res, string(runes[i:i+3]))
+		}
+		res = append(res, " "+word+" ")
+		if letters += len(runes) - 2; letters >= maxLetters {
+			break
+		}
+	}
+	return res
+}
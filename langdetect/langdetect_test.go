@@ -0,0 +1,70 @@
+package langdetect
+
+import "testing"
+
+func TestDetectScript(t *testing.T) {
+	tests := []struct {
+		want string
+		text string
+	}{
+		{"ja", "東京で新しい技術の展示会が開催されました。"},
+		{"ja", "コンピュータ"},
+		{"ko", "서울에서 새로운 기술 박람회가 열렸습니다."},
+		{"zh", "北京今天发布了新的经济数据，显示增长放缓。"},
+		{"ru", "В Москве открылась новая выставка технологий."},
+		{"el", "Στην Αθήνα άνοιξε μια νέα έκθεση τεχνολογίας."},
+		{"ar", "افتتح معرض جديد للتكنولوجيا في القاهرة."},
+		{"he", "תערוכת טכנולוגיה חדשה נפתחה בתל אביב."},
+		{"th", "กรุงเทพมหานครเป็นเมืองหลวงของประเทศไทย"},
+		{"hi", "दिल्ली में एक नई प्रौद्योगिकी प्रदर्शनी खुली।"},
+		// Mostly Cyrillic despite a Latin name.
+		{"ru", "Компания Google представила новый телефон в Москве."},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDetectLatin(t *testing.T) {
+	tests := []struct {
+		want string
+		text string
+	}{
+		{"en", "The city council approved a new plan on Tuesday to build more affordable housing near the river, " +
+			"after months of debate about the cost and the impact on local businesses."},
+		{"de", "Der Stadtrat hat am Dienstag einen neuen Plan beschlossen, mehr bezahlbare Wohnungen am Fluss zu " +
+			"bauen, nachdem monatelang über die Kosten und die Folgen für die Geschäfte gestritten wurde."},
+		{"fr", "Le conseil municipal a approuvé mardi un nouveau projet pour construire davantage de logements " +
+			"abordables près de la rivière, après des mois de débat sur le coût et les commerces."},
+		{"es", "El ayuntamiento aprobó el martes un nuevo plan para construir más viviendas asequibles cerca del " +
+			"río, después de meses de debate sobre el coste y el impacto en los comercios locales."},
+		{"it", "Il consiglio comunale ha approvato martedì un nuovo piano per costruire più case a prezzi " +
+			"accessibili vicino al fiume, dopo mesi di dibattito sui costi e sui negozi della zona."},
+		{"pt", "A câmara municipal aprovou na terça-feira um novo plano para construir mais habitações a preços " +
+			"acessíveis perto do rio, depois de meses de debate sobre o custo e o comércio local."},
+		{"nl", "De gemeenteraad heeft dinsdag een nieuw plan goedgekeurd om meer betaalbare woningen bij de " +
+			"rivier te bouwen, na maanden van discussie over de kosten en de gevolgen voor winkels."},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDetectUnsure(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"   ",
+		"12345 !?",
+		"Hello world",
+		"Go 1.22 released",
+		"https://example.com/feed.xml",
+	} {
+		if got := Detect(text); got != "" {
+			t.Errorf("Detect(%q) = %q, want none", text, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package langdetect
+
+// Sample texts the trigram profiles of the Latin script languages are learnt from, by ISO 639-1
+// code: the first articles of the Universal Declaration of Human Rights, and sentences of news.
+var samples = map[string]string{
+	"en": `All human beings are born free and equal in dignity and rights. They are endowed with reason
+		and conscience and should act towards one another in a spirit of brotherhood. Everyone is
+		entitled to all the rights and freedoms set forth in this Declaration, without distinction of
+		any kind, such as race, colour, sex, language, religion, political or other opinion, national or
+		social origin, property, birth or other status. The government announced on Monday that the new
+		policy will take effect next year, although several companies have said that they would need
+		more time to prepare. Researchers found that the software was used by thousands of people who
+		were not aware of the changes. This release brings faster builds, a new version of the library
+		and many improvements to the documentation, which you can read about below.`,
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie sind mit Vernunft und
+		Gewissen begabt und sollen einander im Geist der Brüderlichkeit begegnen. Jeder hat Anspruch auf
+		die in dieser Erklärung verkündeten Rechte und Freiheiten ohne irgendeinen Unterschied, etwa nach
+		Rasse, Hautfarbe, Geschlecht, Sprache, Religion, politischer oder sonstiger Überzeugung,
+		nationaler oder sozialer Herkunft, Vermögen, Geburt oder sonstigem Stand. Die Regierung kündigte
+		am Montag an, dass die neue Regelung im nächsten Jahr in Kraft treten soll, obwohl mehrere
+		Unternehmen erklärt haben, dass sie mehr Zeit für die Vorbereitung brauchen. Forscher haben
+		herausgefunden, dass die Software von Tausenden Menschen genutzt wurde, die nichts von den
+		Änderungen wussten. Diese Version bringt schnellere Builds, eine neue Version der Bibliothek und
+		viele Verbesserungen der Dokumentation, über die Sie unten mehr lesen können.`,
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués de
+		raison et de conscience et doivent agir les uns envers les autres dans un esprit de fraternité.
+		Chacun peut se prévaloir de tous les droits et de toutes les libertés proclamés dans la présente
+		Déclaration, sans distinction aucune, notamment de race, de couleur, de sexe, de langue, de
+		religion, d'opinion politique ou de toute autre opinion, d'origine nationale ou sociale, de
+		fortune, de naissance ou de toute autre situation. Le gouvernement a annoncé lundi que la
+		nouvelle politique entrera en vigueur l'année prochaine, bien que plusieurs entreprises aient
+		déclaré qu'elles auraient besoin de plus de temps pour se préparer. Les chercheurs ont découvert
+		que le logiciel était utilisé par des milliers de personnes qui n'étaient pas au courant des
+		changements. Cette version apporte des compilations plus rapides, une nouvelle version de la
+		bibliothèque et de nombreuses améliorations de la documentation, que vous pouvez lire ci-dessous.`,
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como están
+		de razón y conciencia, deben comportarse fraternalmente los unos con los otros. Toda persona
+		tiene todos los derechos y libertades proclamados en esta Declaración, sin distinción alguna de
+		raza, color, sexo, idioma, religión, opinión política o de cualquier otra índole, origen
+		nacional o social, posición económica, nacimiento o cualquier otra condición. El gobierno
+		anunció el lunes que la nueva política entrará en vigor el próximo año, aunque varias empresas
+		han dicho que necesitarían más tiempo para prepararse. Los investigadores descubrieron que el
+		programa era utilizado por miles de personas que no conocían los cambios. Esta versión trae
+		compilaciones más rápidas, una nueva versión de la biblioteca y muchas mejoras en la
+		documentación, sobre las que puede leer a continuación.`,
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti. Essi sono dotati di
+		ragione e di coscienza e devono agire gli uni verso gli altri in spirito di fratellanza. Ad ogni
+		individuo spettano tutti i diritti e tutte le libertà enunciate nella presente Dichiarazione,
+		senza distinzione alcuna, per ragioni di razza, di colore, di sesso, di lingua, di religione, di
+		opinione politica o di altro genere, di origine nazionale o sociale, di ricchezza, di nascita o
+		di altra condizione. Il governo ha annunciato lunedì che la nuova politica entrerà in vigore il
+		prossimo anno, anche se diverse aziende hanno detto che avrebbero bisogno di più tempo per
+		prepararsi. I ricercatori hanno scoperto che il programma era usato da migliaia di persone che
+		non erano a conoscenza dei cambiamenti. Questa versione porta compilazioni più veloci, una nuova
+		versione della libreria e molti miglioramenti alla documentazione, di cui potete leggere qui sotto.`,
+	"pt": `Todos os seres humanos nascem livres e iguais em dignidade e em direitos. Dotados de razão e
+		de consciência, devem agir uns para com os outros em espírito de fraternidade. Todos os seres
+		humanos podem invocar os direitos e as liberdades proclamados na presente Declaração, sem
+		distinção alguma, nomeadamente de raça, de cor, de sexo, de língua, de religião, de opinião
+		política ou outra, de origem nacional ou social, de fortuna, de nascimento ou de qualquer outra
+		situação. O governo anunciou na segunda-feira que a nova política entrará em vigor no próximo
+		ano, embora várias empresas tenham dito que precisariam de mais tempo para se preparar. Os
+		pesquisadores descobriram que o programa era usado por milhares de pessoas que não sabiam das
+		mudanças. Esta versão traz compilações mais rápidas, uma nova versão da biblioteca e muitas
+		melhorias na documentação, sobre as quais você pode ler abaixo.`,
+	"nl": `Alle mensen worden vrij en gelijk in waardigheid en rechten geboren. Zij zijn begiftigd met
+		verstand en geweten, en behoren zich jegens elkander in een geest van broederschap te gedragen.
+		Een ieder heeft aanspraak op alle rechten en vrijheden, in deze Verklaring opgesomd, zonder
+		onderscheid van welke aard ook, zoals ras, kleur, geslacht, taal, godsdienst, politieke of andere
+		overtuiging, nationale of maatschappelijke afkomst, eigendom, geboorte of andere status. De
+		regering maakte maandag bekend dat het nieuwe beleid volgend jaar van kracht wordt, hoewel
+		verschillende bedrijven hebben gezegd dat ze meer tijd nodig hebben om zich voor te bereiden.
+		Onderzoekers ontdekten dat de software werd gebruikt door duizenden mensen die niet op de hoogte
+		waren van de veranderingen. Deze versie brengt snellere builds, een nieuwe versie van de
+		bibliotheek en veel verbeteringen aan de documentatie, waarover u hieronder meer kunt lezen.`,
+}
@@ -0,0 +1,109 @@
+package libstore
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Moves the jobs due for a retry back to the queue. KEYS are the retry set and the queue; ARGV is
+// the current time in Unix seconds.
+var promoteDueScript = redis.NewScript(2, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, job in ipairs(due) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('LPUSH', KEYS[2], job)
+end
+return #due
+`)
+
+// Queue is a durable FIFO queue of jobs kept in Redis. A popped job stays in a processing list
+// until acknowledged or scheduled for a retry, so that jobs in progress when the server stops are
+// not lost. Jobs should be unique, e.g. by carrying an ID and attempt number.
+type Queue struct {
+	rs RedisStrore
+	// List of pending jobs, with the processing list and retry set derived from it.
+	key string
+}
+
+// NewQueue creates a queue kept at the key.
+func NewQueue(rs RedisStrore, key string) *Queue {
+	return &Queue{rs: rs, key: key}
+}
+
+func (q *Queue) processingKey() string {
+	return q.key + ":processing"
+}
+
+func (q *Queue) retryKey() string {
+	return q.key + ":retry"
+}
+
+// Push appends a job to the queue.
+func (q *Queue) Push(job []byte) error {
+	return q.rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("LPUSH", q.key, job)
+		return err
+	})
+}
+
+// Pop takes the next job into processing, waiting up to `timeout` for one. Return nil if none.
+func (q *Queue) Pop(timeout time.Duration) (job []byte, err error) {
+	err = q.rs.Do(func(c redis.Conn) (err error) {
+		job, err = redis.Bytes(c.Do("BRPOPLPUSH", q.key, q.processingKey(), int(timeout.Seconds())))
+		return
+	})
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return
+}
+
+// Ack removes a processed job.
+func (q *Queue) Ack(job []byte) error {
+	return q.rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("LREM", q.processingKey(), 1, job)
+		return err
+	})
+}
+
+// RetryAt replaces a job in processing by `retry`, which is queued again at the given time by
+// PromoteDue.
+func (q *Queue) RetryAt(job, retry []byte, at time.Time) error {
+	return q.rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("ZADD", q.retryKey(), at.Unix(), retry)
+		c.Send("LREM", q.processingKey(), 1, job)
+		_, err := c.Do("EXEC")
+		return err
+	})
+}
+
+// PromoteDue queues the jobs whose retry is due, return their number.
+func (q *Queue) PromoteDue() (n int, err error) {
+	err = q.rs.Do(func(c redis.Conn) (err error) {
+		n, err = redis.Int(promoteDueScript.Do(c, q.retryKey(), q.key, time.Now().Unix()))
+		return
+	})
+	return
+}
+
+// Requeue moves the jobs left in processing back to the queue, return their number. Only to be
+// called when no job is being processed, e.g. on startup of the only server.
+func (q *Queue) Requeue() (n int, err error) {
+	for {
+		var moved bool
+		err = q.rs.Do(func(c redis.Conn) error {
+			_, err := redis.Bytes(c.Do("RPOPLPUSH", q.processingKey(), q.key))
+			if err == redis.ErrNil {
+				return nil
+			}
+			moved = err == nil
+			return err
+		})
+		if err != nil || !moved {
+			return
+		}
+		n++
+	}
+}
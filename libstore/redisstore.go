@@ -1,14 +1,29 @@
 package libstore
 
 import (
+	"io"
+	"log"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
+// Attempts of an operation failing with transient connection errors, and the delay before the
+// first retry which doubles after each one.
+const (
+	maxAttempts  = 3
+	initialDelay = 100 * time.Millisecond
+)
+
 // RedisStrore is the interface for underlying Redis persistence store.
 type RedisStrore interface {
 	GetConnection() redis.Conn
+	// Do runs `fn` with a connection, retrying with backoff if it fails with a transient connection
+	// error. The commands may have been executed before the connection broke, so `fn` should be
+	// idempotent, or at least harmless to repeat.
+	Do(fn func(c redis.Conn) error) error
 }
 
 type redisStore struct {
@@ -43,3 +58,38 @@ func NewStore(redisServer string) RedisStrore {
 func (rs *redisStore) GetConnection() redis.Conn {
 	return rs.pool.Get()
 }
+
+func (rs *redisStore) Do(fn func(c redis.Conn) error) error {
+	delay := initialDelay
+	for attempt := 1; ; attempt++ {
+		c := rs.pool.Get()
+		err := fn(c)
+		// A broken connection is discarded rather than returned to the pool.
+		c.Close()
+		if err == nil || !isTransient(err) || attempt == maxAttempts {
+			return err
+		}
+		log.Printf("[i] Retrying Redis operation in %v after: %v\n", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Whether the error is due to the connection rather than the command, e.g. Redis restarting.
+func isTransient(err error) bool {
+	if _, ok := err.(redis.Error); ok {
+		// Error reply of the server.
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == redis.ErrPoolExhausted {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "use of closed network connection") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "connection refused")
+}
@@ -0,0 +1,328 @@
+// Package storetest provides an in-memory Redis store for tests of the packages built on libstore,
+// supporting the hash, set and list commands they use, including MULTI/EXEC. WATCH is accepted
+// and never aborts a transaction.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Store is an in-memory Redis store, implementing libstore.RedisStrore.
+type Store struct {
+	mu     sync.Mutex
+	Hashes map[string]map[string]string
+	Sets   map[string]map[string]bool
+	Lists  map[string][]string
+	// String values, by key.
+	Strings map[string]string
+	// Errors returned by commands, by name, e.g. "SREM".
+	Errors map[string]error
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{
+		Hashes:  make(map[string]map[string]string),
+		Sets:    make(map[string]map[string]bool),
+		Lists:   make(map[string][]string),
+		Strings: make(map[string]string),
+		Errors:  make(map[string]error),
+	}
+}
+
+// GetConnection returns a connection to the store.
+func (s *Store) GetConnection() redis.Conn {
+	return &conn{s: s}
+}
+
+// Do runs `fn` with a connection to the store.
+func (s *Store) Do(fn func(c redis.Conn) error) error {
+	return fn(s.GetConnection())
+}
+
+// HSet sets a field of a hash.
+func (s *Store) HSet(key, field, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hset(key, field, value)
+}
+
+// SAdd adds members to a set.
+func (s *Store) SAdd(key string, members ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sadd(key, members)
+}
+
+func (s *Store) hset(key, field, value string) {
+	if s.Hashes[key] == nil {
+		s.Hashes[key] = make(map[string]string)
+	}
+	s.Hashes[key][field] = value
+}
+
+func (s *Store) sadd(key string, members []string) int64 {
+	if s.Sets[key] == nil {
+		s.Sets[key] = make(map[string]bool)
+	}
+	var added int64
+	for _, m := range members {
+		if !s.Sets[key][m] {
+			s.Sets[key][m] = true
+			added++
+		}
+	}
+	return added
+}
+
+type command struct {
+	name string
+	args []string
+}
+
+type conn struct {
+	s *Store
+	// Commands queued by Send, and those queued within MULTI until EXEC.
+	queued []command
+	multi  bool
+	tx     []command
+	// Replies of flushed commands, for Receive.
+	replies []interface{}
+}
+
+func (c *conn) Close() error { return nil }
+func (c *conn) Err() error   { return nil }
+
+func (c *conn) Send(name string, args ...interface{}) error {
+	c.queued = append(c.queued, command{name, stringArgs(args)})
+	return nil
+}
+
+func (c *conn) Flush() error {
+	for _, cmd := range c.queued {
+		reply, err := c.exec(cmd)
+		if err != nil {
+			reply = redis.Error(err.Error())
+		}
+		c.replies = append(c.replies, reply)
+	}
+	c.queued = nil
+	return nil
+}
+
+func (c *conn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		return nil, errors.New("storetest: no pending reply")
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Run the commands sent before, whose replies are dropped, then the command.
+func (c *conn) Do(name string, args ...interface{}) (interface{}, error) {
+	c.Flush()
+	c.replies = nil
+	return c.exec(command{name, stringArgs(args)})
+}
+
+// Run a command, or queue it within MULTI.
+func (c *conn) exec(cmd command) (interface{}, error) {
+	switch cmd.name {
+	case "MULTI":
+		c.multi = true
+		return "OK", nil
+	case "EXEC":
+		c.multi = false
+		var replies []interface{}
+		for _, queued := range c.tx {
+			reply, err := c.s.run(queued)
+			if err != nil {
+				reply = redis.Error(err.Error())
+			}
+			replies = append(replies, reply)
+		}
+		c.tx = nil
+		return replies, nil
+	case "WATCH", "UNWATCH":
+		return "OK", nil
+	}
+	if c.multi {
+		c.tx = append(c.tx, cmd)
+		return "QUEUED", nil
+	}
+	return c.s.run(cmd)
+}
+
+func (s *Store) run(cmd command) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Errors[cmd.name]; err != nil {
+		return nil, err
+	}
+	args := cmd.args
+	switch cmd.name {
+	case "HGET":
+		if v, ok := s.Hashes[args[0]][args[1]]; ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	case "HSET", "HSETNX":
+		_, exists := s.Hashes[args[0]][args[1]]
+		if exists && cmd.name == "HSETNX" {
+			return int64(0), nil
+		}
+		s.hset(args[0], args[1], args[2])
+		return boolInt(!exists), nil
+	case "HMSET":
+		for i := 1; i+1 < len(args); i += 2 {
+			s.hset(args[0], args[i], args[i+1])
+		}
+		return "OK", nil
+	case "HDEL":
+		var deleted int64
+		for _, field := range args[1:] {
+			if _, ok := s.Hashes[args[0]][field]; ok {
+				delete(s.Hashes[args[0]], field)
+				deleted++
+			}
+		}
+		return deleted, nil
+	case "HEXISTS":
+		_, ok := s.Hashes[args[0]][args[1]]
+		return boolInt(ok), nil
+	case "HVALS", "HKEYS", "HGETALL":
+		var fields []string
+		for k := range s.Hashes[args[0]] {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		var res []interface{}
+		for _, k := range fields {
+			if cmd.name != "HVALS" {
+				res = append(res, []byte(k))
+			}
+			if cmd.name != "HKEYS" {
+				res = append(res, []byte(s.Hashes[args[0]][k]))
+			}
+		}
+		return res, nil
+	case "HMGET":
+		var res []interface{}
+		for _, field := range args[1:] {
+			if v, ok := s.Hashes[args[0]][field]; ok {
+				res = append(res, []byte(v))
+			} else {
+				res = append(res, nil)
+			}
+		}
+		return res, nil
+	case "SADD":
+		return s.sadd(args[0], args[1:]), nil
+	case "SREM":
+		var removed int64
+		for _, m := range args[1:] {
+			if s.Sets[args[0]][m] {
+				delete(s.Sets[args[0]], m)
+				removed++
+			}
+		}
+		return removed, nil
+	case "SMEMBERS":
+		var members []string
+		for m := range s.Sets[args[0]] {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		var res []interface{}
+		for _, m := range members {
+			res = append(res, []byte(m))
+		}
+		return res, nil
+	case "LPUSH":
+		for _, v := range args[1:] {
+			s.Lists[args[0]] = append([]string{v}, s.Lists[args[0]]...)
+		}
+		return int64(len(s.Lists[args[0]])), nil
+	case "LTRIM", "LRANGE":
+		list := s.Lists[args[0]]
+		start, stop := index(args[1], len(list)), index(args[2], len(list))+1
+		if stop > len(list) {
+			stop = len(list)
+		}
+		if start > stop {
+			start = stop
+		}
+		if cmd.name == "LTRIM" {
+			s.Lists[args[0]] = append([]string(nil), list[start:stop]...)
+			return "OK", nil
+		}
+		var res []interface{}
+		for _, v := range list[start:stop] {
+			res = append(res, []byte(v))
+		}
+		return res, nil
+	case "GET":
+		if v, ok := s.Strings[args[0]]; ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	case "SET":
+		s.Strings[args[0]] = args[1]
+		return "OK", nil
+	case "DEL":
+		var deleted int64
+		for _, key := range args {
+			_, isString := s.Strings[key]
+			if s.Hashes[key] != nil || s.Sets[key] != nil || s.Lists[key] != nil || isString {
+				deleted++
+			}
+			delete(s.Strings, key)
+			delete(s.Hashes, key)
+			delete(s.Sets, key)
+			delete(s.Lists, key)
+		}
+		return deleted, nil
+	}
+	return nil, fmt.Errorf("storetest: unsupported command %s", cmd.name)
+}
+
+// Index of a list from a Redis index, which counts from the end if negative.
+func index(arg string, n int) int {
+	i, _ := strconv.Atoi(arg)
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func stringArgs(args []interface{}) []string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			res[i] = string(b)
+		} else {
+			res[i] = fmt.Sprint(arg)
+		}
+	}
+	return res
+}
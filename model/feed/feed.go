@@ -2,18 +2,24 @@ package feed
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"strings"
 
+	"github.com/edfward/readkey/keyword"
 	"github.com/edfward/readkey/libstore"
 	"github.com/edfward/readkey/util"
 
 	"github.com/garyburd/redigo/redis"
 )
 
-const (
-	latestFeedCapacity  = 100
-	initUnreadFeedCount = 15
-)
+const latestFeedCapacity = 100
+
+// InitUnreadFeedCount is the number of latest feed items which are unread for a new subscriber.
+const InitUnreadFeedCount = 15
+
+// ErrNotFound is returned when the requested record doesn't exist.
+var ErrNotFound = errors.New("not found")
 
 var rs libstore.RedisStrore
 
@@ -28,182 +34,494 @@ type Source struct {
 	SourceID string `json:"id"`
 	Title    string `json:"title"`
 	URL      string `json:"url"`
+	// Link to the site itself, as announced by the channel.
+	Link string `json:"link,omitempty"`
+	// URL the source was first listened at if the feed has moved since, whose hash the ID is.
+	Origin string `json:"origin,omitempty"`
 }
 
 // ItemEntry describes an entry struct to the actual feed item, so only contains
 // a subset of the information. Serialized as JSON, not in Redis top level.
 type ItemEntry struct {
-	FeedID   string `json:"id"`
-	Title    string `json:"title"`
-	Keywords string `json:"keywords"`
-	PubDate  string `json:"pubDate"`
+	FeedID string `json:"id"`
+	Title  string `json:"title"`
+	// Keywords of the item, most relevant first.
+	Keywords []keyword.Keyword `json:"keywordList"`
+	// Deprecated: the keywords joined by commas, as entries used to keep them, for clients of the
+	// former format. Derived from `Keywords` when serialized.
+	KeywordsText string `json:"keywords"`
+	PubDate      string `json:"pubDate"`
+	// Language tag of the item in lower case, e.g. "en" or "en-us", as announced by the feed or
+	// otherwise detected. Empty if unknown.
+	Lang string `json:"lang,omitempty"`
+	// Unix time the entry was stored at, zero for entries stored before it was kept.
+	Added int64 `json:"added,omitempty"`
+	// Extractive summary of a few sentences of the item, if its source is summarized.
+	Summary string `json:"summary,omitempty"`
 }
 
-// Item keeps the actual feed item, which are stored into top-level Redis
-type Item struct {
-	Link    string `json:"link" redis:"link"`
-	Content string `json:"content" redis:"content"`
+// MarshalJSON serializes the entry together with the deprecated comma-joined keywords.
+func (fe ItemEntry) MarshalJSON() ([]byte, error) {
+	type plainEntry ItemEntry
+	p := plainEntry(fe)
+	if p.Keywords == nil {
+		p.Keywords = []keyword.Keyword{}
+	}
+	p.KeywordsText = keyword.Join(p.Keywords)
+	return json.Marshal(p)
 }
 
-// GetSourceSubscribers retrieves subscribed user IDs.
-// TODO: Currently even if a user unsubscribes a feed source, the subscriber list
-// of that source doesn't remove that user.
-func GetSourceSubscribers(srcID string) []string {
-	c := rs.GetConnection()
-	defer c.Close()
+// UnmarshalJSON deserializes the entry, taking the comma-joined keywords of entries stored before
+// they were kept as a list.
+func (fe *ItemEntry) UnmarshalJSON(data []byte) error {
+	type plainEntry ItemEntry
+	var p plainEntry
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if p.Keywords == nil {
+		p.Keywords = keyword.Split(p.KeywordsText)
+	}
+	*fe = ItemEntry(p)
+	return nil
+}
 
-	subKey := util.FormatSubscriberKey(srcID)
-	subers, err := redis.Strings(c.Do("LRANGE", subKey, 0, -1))
-	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get feed source subscribers: %v\n", err.Error())
-		return nil
+// MatchesKeyword tells whether the title or a keyword of the entry contains `s`, ignoring case.
+func (fe ItemEntry) MatchesKeyword(s string) bool {
+	s = strings.ToLower(s)
+	if strings.Contains(strings.ToLower(fe.Title), s) {
+		return true
 	}
+	for _, kw := range fe.Keywords {
+		if strings.Contains(strings.ToLower(kw.Text), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesLang tells whether the entry is in the language of tag `lang`, e.g. "en" matches entries
+// in "en" and "en-us", ignoring case.
+func (fe ItemEntry) MatchesLang(lang string) bool {
+	lang = NormalizeLang(lang)
+	return fe.Lang == lang || strings.HasPrefix(fe.Lang, lang+"-")
+}
 
-	return subers
+// NormalizeLang returns the language tag in lower case with hyphens, e.g. "en-us" of "en_US".
+func NormalizeLang(lang string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(lang)), "_", "-", -1)
 }
 
-// AddSourceSubscriber adds a user to a feed source's subscriber list.
-func AddSourceSubscriber(srcID, user string) {
-	c := rs.GetConnection()
-	defer c.Close()
+// Item keeps the actual feed item, which are stored into top-level Redis
+type Item struct {
+	Link    string `json:"link" redis:"link"`
+	Content string `json:"content" redis:"content"`
+}
 
-	subKey := util.FormatSubscriberKey(srcID)
-	if _, err := c.Do("RPUSH", subKey, user); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to append subscriber to a feed source.\n")
+// GetSourceSubscribers retrieves subscribed user IDs. Subscribers are added and removed together
+// with the subscriptions of users, see user.Subscribe.
+func GetSourceSubscribers(srcID string) (subers []string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		subers, err = redis.Strings(c.Do("SMEMBERS", util.FormatSubscriberKey(srcID)))
+		return
+	})
+	return
+}
+
+// MigrateSourceSubscribers converts the subscribers of feed sources from the former lists, which
+// could hold duplicates, to sets. No-op for sources already migrated.
+func MigrateSourceSubscribers(srcIDs []string) error {
+	var migrated int
+	err := rs.Do(func(c redis.Conn) error {
+		for _, srcID := range srcIDs {
+			subKey := util.FormatSubscriberKey(srcID)
+			keyType, err := redis.String(c.Do("TYPE", subKey))
+			if err != nil {
+				return err
+			} else if keyType != "list" {
+				continue
+			}
+
+			subers, err := redis.Strings(c.Do("LRANGE", subKey, 0, -1))
+			if err != nil {
+				return err
+			}
+			c.Send("MULTI")
+			c.Send("DEL", subKey)
+			c.Send("SADD", redis.Args{}.Add(subKey).AddFlat(subers)...)
+			if _, err := c.Do("EXEC"); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	if migrated > 0 {
+		log.Printf("[i] Migrated subscribers of %d feed source(s)\n", migrated)
 	}
+	return err
 }
 
 // GetItemEntriesFromSource returns a list of feed item entries given a list
-// of feed IDs.
-func GetItemEntriesFromSource(srcID string, feedIDs []string) []ItemEntry {
-	c := rs.GetConnection()
-	defer c.Close()
-
+// of feed IDs, leaving out those not found.
+func GetItemEntriesFromSource(srcID string, feedIDs []string) ([]ItemEntry, error) {
 	if len(feedIDs) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	entries, err := redis.Strings(c.Do("HMGET", redis.Args{}.Add(srcID).AddFlat(feedIDs)...))
+	var entries []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		entries, err = redis.Strings(c.Do("HMGET", redis.Args{}.Add(srcID).AddFlat(feedIDs)...))
+		return
+	})
 	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get feed entries from a source.\n")
-		return nil
+		return nil, err
 	}
 
-	var fe ItemEntry
 	res := make([]ItemEntry, 0, len(entries))
 	for _, entry := range entries {
+		// Entries are only added once keywords are fetched, skip those missing.
+		if entry == "" {
+			continue
+		}
+		var fe ItemEntry
 		json.Unmarshal([]byte(entry), &fe)
 		res = append(res, fe)
 	}
-	return res
+	return res, nil
 }
 
-// AppendLatestItemIDToSource appends a feed ID to the latest queue (a capped list) of a feed source.
-func AppendLatestItemIDToSource(srcID, feedID string) {
-	c := rs.GetConnection()
-	defer c.Close()
+// Format version of the stored item entries, 1 since keywords are kept as a list.
+const itemEntriesVersion = 1
+
+// MigrateItemEntries rewrites the item entries of feed sources which keep keywords joined by commas,
+// keeping them as a list instead. Only done once, recorded by the version of the entries.
+func MigrateItemEntries(srcIDs []string) error {
+	versionKey := util.FormatItemEntriesVersionKey()
+	var version int
+	err := rs.Do(func(c redis.Conn) (err error) {
+		version, err = redis.Int(c.Do("GET", versionKey))
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return
+	})
+	if err != nil || version >= itemEntriesVersion {
+		return err
+	}
 
-	latestKey := util.FormatLatestFeedsKey(srcID)
-	c.Send("MULTI")
-	c.Send("LPUSH", latestKey, feedID)
-	c.Send("LTRIM", latestKey, 0, latestFeedCapacity-1)
-	if _, err := c.Do("EXEC"); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to push latest feed ID to a source.\n")
+	var migrated int
+	for _, srcID := range srcIDs {
+		n, err := migrateSourceEntries(srcID)
+		if err != nil {
+			return err
+		}
+		migrated += n
 	}
+	if migrated > 0 {
+		log.Printf("[i] Migrated keywords of %d feed entries\n", migrated)
+	}
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("SET", versionKey, itemEntriesVersion)
+		return err
+	})
 }
 
-// GetLatestItemIdsFromSource fetches the latest feed IDs of a feed source.
-func GetLatestItemIdsFromSource(srcID string) []string {
-	c := rs.GetConnection()
-	defer c.Close()
+// Attempts of an update of item entries conflicting with others, e.g. a migration racing feed
+// handlers storing new items, or the keywords and summary of an item stored by two backfill workers
+// at once.
+const maxUpdateAttempts = 5
+
+var errUpdateConflict = errors.New("item entries kept changing while updated")
+
+// Migrate the item entries of a feed source in a transaction, retried if they changed meanwhile, e.g.
+// by feed handlers already running, return the number of entries migrated.
+func migrateSourceEntries(srcID string) (int, error) {
+	var migrated int
+	err := rs.Do(func(c redis.Conn) error {
+		for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+			if _, err := c.Do("WATCH", srcID); err != nil {
+				return err
+			}
+			entries, err := redis.StringMap(c.Do("HGETALL", srcID))
+			if err != nil {
+				return err
+			}
+			args := redis.Args{}.Add(srcID)
+			for feedID, entry := range entries {
+				var raw map[string]json.RawMessage
+				if err := json.Unmarshal([]byte(entry), &raw); err != nil {
+					log.Printf("[w] Skipped malformed feed entry %s of %s: %v\n", feedID, srcID, err)
+					continue
+				} else if _, ok := raw["keywordList"]; ok {
+					continue
+				}
+				var fe ItemEntry
+				json.Unmarshal([]byte(entry), &fe)
+				fePacket, _ := json.Marshal(fe)
+				args = args.Add(feedID, fePacket)
+			}
+			if len(args) == 1 {
+				_, err := c.Do("UNWATCH")
+				return err
+			}
+			c.Send("MULTI")
+			c.Send("HMSET", args...)
+			replies, err := c.Do("EXEC")
+			if err != nil {
+				return err
+			} else if replies != nil {
+				migrated = (len(args) - 1) / 2
+				return nil
+			}
+			// Aborted, as the source's entries changed after WATCH.
+		}
+		return errUpdateConflict
+	})
+	return migrated, err
+}
 
+// GetItemEntryIDs returns the feed IDs of all item entries of a feed source.
+func GetItemEntryIDs(srcID string) (feedIDs []string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		feedIDs, err = redis.Strings(c.Do("HKEYS", srcID))
+		return
+	})
+	return
+}
+
+// SetItemEntryKeywords replaces the keywords of an item entry of a feed source, and sets its
+// language if unknown so far, return ErrNotFound if there's no such entry.
+func SetItemEntryKeywords(srcID, feedID string, keywords []keyword.Keyword, lang string) error {
+	return updateItemEntry(srcID, feedID, func(fe *ItemEntry) {
+		fe.Keywords = keywords
+		if fe.Lang == "" {
+			fe.Lang = NormalizeLang(lang)
+		}
+	})
+}
+
+// SetItemEntrySummary replaces the summary of an item entry of a feed source, return ErrNotFound if
+// there's no such entry.
+func SetItemEntrySummary(srcID, feedID, summary string) error {
+	return updateItemEntry(srcID, feedID, func(fe *ItemEntry) {
+		fe.Summary = summary
+	})
+}
+
+// Read, update and write back an item entry in a transaction, retried if it changed meanwhile.
+func updateItemEntry(srcID, feedID string, update func(fe *ItemEntry)) error {
+	return rs.Do(func(c redis.Conn) error {
+		for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+			if _, err := c.Do("WATCH", srcID); err != nil {
+				return err
+			}
+			entry, err := redis.Bytes(c.Do("HGET", srcID, feedID))
+			if err == redis.ErrNil {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			var fe ItemEntry
+			if err := json.Unmarshal(entry, &fe); err != nil {
+				return err
+			}
+			update(&fe)
+			fePacket, _ := json.Marshal(fe)
+			c.Send("MULTI")
+			c.Send("HSET", srcID, feedID, fePacket)
+			replies, err := c.Do("EXEC")
+			if err != nil {
+				return err
+			} else if replies != nil {
+				return nil
+			}
+			// Aborted, as the source's entries changed after WATCH.
+		}
+		return errUpdateConflict
+	})
+}
+
+// AppendLatestItemIDToSource appends a feed ID to the latest queue (a capped list) of a feed source.
+func AppendLatestItemIDToSource(srcID, feedID string) error {
 	latestKey := util.FormatLatestFeedsKey(srcID)
-	feedIDs, err := redis.Strings(c.Do("LRANGE", latestKey, 0, initUnreadFeedCount-1))
-	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get latest feed IDs from a source.\n")
-		return nil
-	}
-	return feedIDs
+	return rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("LPUSH", latestKey, feedID)
+		c.Send("LTRIM", latestKey, 0, latestFeedCapacity-1)
+		_, err := c.Do("EXEC")
+		return err
+	})
 }
 
-// AddItemEntryToSource adds a feed item entry to a feed source.
-func AddItemEntryToSource(srcID string, fe ItemEntry) {
-	c := rs.GetConnection()
-	defer c.Close()
+// GetLatestItemIdsFromSource fetches the latest feed IDs of a feed source.
+func GetLatestItemIdsFromSource(srcID string) (feedIDs []string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		feedIDs, err = redis.Strings(c.Do("LRANGE", util.FormatLatestFeedsKey(srcID), 0, InitUnreadFeedCount-1))
+		return
+	})
+	return
+}
 
-	fePacket, _ := json.Marshal(fe)
-	if _, err := c.Do("HSET", srcID, fe.FeedID, fePacket); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to add feed entry to a source.\n")
-	}
+// GetRecentItemIdsFromSource fetches all feed IDs kept in the latest queue of a feed source, newest first.
+func GetRecentItemIdsFromSource(srcID string) (feedIDs []string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		feedIDs, err = redis.Strings(c.Do("LRANGE", util.FormatLatestFeedsKey(srcID), 0, -1))
+		return
+	})
+	return
 }
 
-// GetItem retrieves the actual content of a feed.
-func GetItem(feedID string) Item {
-	c := rs.GetConnection()
-	defer c.Close()
+// AddItemEntryToSource adds a feed item entry to a feed source unless it has one of the item already,
+// e.g. when the feed is fetched again after a restart, return whether it was added.
+func AddItemEntryToSource(srcID string, fe ItemEntry) (added bool, err error) {
+	fePacket, _ := json.Marshal(fe)
+	err = rs.Do(func(c redis.Conn) (err error) {
+		added, err = redis.Bool(c.Do("HSETNX", srcID, fe.FeedID, fePacket))
+		return
+	})
+	return
+}
 
-	var fi Item
-	v, err := redis.Values(c.Do("HGETALL", feedID))
+// GetItem retrieves the actual content of a feed, return ErrNotFound if there's no such feed.
+func GetItem(feedID string) (Item, error) {
+	var v []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		v, err = redis.Values(c.Do("HGETALL", feedID))
+		return
+	})
 	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get a feed item.\n")
-		return Item{}
+		return Item{}, err
+	} else if len(v) == 0 {
+		return Item{}, ErrNotFound
 	}
 
+	var fi Item
 	if err := redis.ScanStruct(v, &fi); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to scan the feed item.\n")
-		return Item{}
+		return Item{}, err
 	}
-
-	return fi
+	return fi, nil
 }
 
 // SetItem sets the actual content of a feed.
-func SetItem(feedID string, fi Item) {
-	c := rs.GetConnection()
-	defer c.Close()
+func SetItem(feedID string, fi Item) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HMSET", redis.Args{}.Add(feedID).AddFlat(&fi)...)
+		return err
+	})
+}
 
-	if _, err := c.Do("HMSET", redis.Args{}.Add(feedID).AddFlat(&fi)...); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to set a feed item.\n")
-	}
+// MergeSourceEntries copies the feed item entries of a duplicate feed source into the source it's
+// merged into, so that unread feed IDs moved over still resolve. Existing entries are kept.
+func MergeSourceEntries(fromSrcID, intoSrcID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		entries, err := redis.StringMap(c.Do("HGETALL", fromSrcID))
+		if err != nil {
+			return err
+		}
+		c.Send("MULTI")
+		for feedID, entry := range entries {
+			c.Send("HSETNX", intoSrcID, feedID, entry)
+		}
+		_, err = c.Do("EXEC")
+		return err
+	})
+}
+
+// RemoveSource stops listening to a feed source and deletes its entries, latest feed IDs and
+// subscribers. Feed items are left alone since other sources may share them.
+func RemoveSource(srcID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("HDEL", util.FormatListeningKey(), srcID)
+		c.Send("DEL", srcID, util.FormatLatestFeedsKey(srcID), util.FormatSubscriberKey(srcID))
+		_, err := c.Do("EXEC")
+		return err
+	})
+}
+
+// AddSourceAlias records another URL of a feed source, e.g. one it has moved from.
+func AddSourceAlias(url, srcID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatSourceAliasKey(), url, srcID)
+		return err
+	})
+}
+
+// GetSourceAliases fetches the mapping from alias URLs to feed source IDs.
+func GetSourceAliases() (aliases map[string]string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		aliases, err = redis.StringMap(c.Do("HGETALL", util.FormatSourceAliasKey()))
+		return
+	})
+	return
 }
 
 // GetListeningSources fetches all listening feed sources.
 // TODO: For now it only grows but never shrinks.
-func GetListeningSources() []Source {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	srcs, err := redis.Strings(c.Do("SMEMBERS", util.FormatListeningKey()))
+func GetListeningSources() ([]Source, error) {
+	var srcs []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		srcs, err = redis.Strings(c.Do("HVALS", util.FormatListeningKey()))
+		return
+	})
 	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get all listening feed sources.\n")
-		return nil
+		return nil, err
 	}
 
-	var fs Source
 	res := make([]Source, 0, len(srcs))
 	for _, src := range srcs {
+		var fs Source
 		// Assume no unmarshalling error.
 		json.Unmarshal([]byte(src), &fs)
 		res = append(res, fs)
 	}
-	return res
+	return res, nil
 }
 
-// AppendListeningSource appends a feed source to the listening list.
-func AppendListeningSource(src Source) {
-	c := rs.GetConnection()
-	defer c.Close()
-
+// AppendListeningSource adds a feed source to the listening sources, or replaces the stored
+// record if the source is already listened to.
+func AppendListeningSource(src Source) error {
 	srcPacket, _ := json.Marshal(src)
-	c.Do("SADD", util.FormatListeningKey(), srcPacket)
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatListeningKey(), src.SourceID, srcPacket)
+		return err
+	})
+}
+
+// MigrateListeningSources converts the listening sources from the former set of serialized
+// sources to a hash keyed by source ID, so that a record can be updated in place. No-op if
+// already migrated.
+func MigrateListeningSources() error {
+	listeningKey := util.FormatListeningKey()
+	var migrated int
+	err := rs.Do(func(c redis.Conn) error {
+		keyType, err := redis.String(c.Do("TYPE", listeningKey))
+		if err != nil || keyType != "set" {
+			return err
+		}
+
+		srcs, err := redis.Strings(c.Do("SMEMBERS", listeningKey))
+		if err != nil {
+			return err
+		}
+		c.Send("MULTI")
+		c.Send("DEL", listeningKey)
+		for _, src := range srcs {
+			var fs Source
+			if err := json.Unmarshal([]byte(src), &fs); err != nil {
+				continue
+			}
+			c.Send("HSET", listeningKey, fs.SourceID, src)
+		}
+		if _, err := c.Do("EXEC"); err != nil {
+			return err
+		}
+		migrated = len(srcs)
+		return nil
+	})
+	if err == nil && migrated > 0 {
+		log.Printf("[i] Migrated %d listening feed source(s)\n", migrated)
+	}
+	return err
 }
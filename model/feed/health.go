@@ -0,0 +1,117 @@
+package feed
+
+import (
+	"time"
+
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// A feed source failing this many times in a row is regarded as dead.
+const deadFailureCount = 10
+
+// Health describes how fetching a feed source went recently, which are stored into top-level Redis.
+// Times are in Unix seconds, zero if never happened.
+type Health struct {
+	LastSuccess         int64  `json:"lastSuccess" redis:"lastSuccess"`
+	LastFailure         int64  `json:"lastFailure" redis:"lastFailure"`
+	LastError           string `json:"lastError" redis:"lastError"`
+	ConsecutiveFailures int64  `json:"consecutiveFailures" redis:"consecutiveFailures"`
+	// Status code of the last response, zero if no response was received.
+	HTTPStatus int `json:"httpStatus" redis:"httpStatus"`
+	// Whether the last failure was due to an unparsable feed document.
+	ParseError bool `json:"parseError" redis:"parseError"`
+	// Derived from the consecutive failures, not stored.
+	Dead bool `json:"dead" redis:"-"`
+}
+
+// RecordFetchSuccess records a successful fetch of a feed source, resetting its failure count.
+func RecordFetchSuccess(srcID string, httpStatus int) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HMSET", util.FormatSourceHealthKey(srcID),
+			"lastSuccess", time.Now().Unix(),
+			"httpStatus", httpStatus,
+			"parseError", false,
+			"consecutiveFailures", 0)
+		return err
+	})
+}
+
+// RecordFetchFailure records a failed fetch of a feed source, return the number of consecutive failures.
+func RecordFetchFailure(srcID string, httpStatus int, errMsg string, parseError bool) (int64, error) {
+	healthKey := util.FormatSourceHealthKey(srcID)
+	var replies []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		c.Send("MULTI")
+		c.Send("HMSET", healthKey,
+			"lastFailure", time.Now().Unix(),
+			"lastError", errMsg,
+			"httpStatus", httpStatus,
+			"parseError", parseError)
+		c.Send("HINCRBY", healthKey, "consecutiveFailures", 1)
+		replies, err = redis.Values(c.Do("EXEC"))
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int64(replies[1], nil)
+}
+
+// GetSourceHealth retrieves the health of a feed source, return ErrNotFound if nothing is recorded.
+func GetSourceHealth(srcID string) (Health, error) {
+	var v []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		v, err = redis.Values(c.Do("HGETALL", util.FormatSourceHealthKey(srcID)))
+		return
+	})
+	if err != nil {
+		return Health{}, err
+	} else if len(v) == 0 {
+		return Health{}, ErrNotFound
+	}
+	return scanHealth(v)
+}
+
+// GetSourcesHealth retrieves the health of several feed sources, keyed by source ID. Sources
+// without any record are left out.
+func GetSourcesHealth(srcIDs []string) (map[string]Health, error) {
+	res := make(map[string]Health, len(srcIDs))
+	err := rs.Do(func(c redis.Conn) error {
+		for _, srcID := range srcIDs {
+			c.Send("HGETALL", util.FormatSourceHealthKey(srcID))
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		for _, srcID := range srcIDs {
+			v, err := redis.Values(c.Receive())
+			if err != nil {
+				return err
+			}
+			if len(v) == 0 {
+				continue
+			}
+			h, err := scanHealth(v)
+			if err != nil {
+				return err
+			}
+			res[srcID] = h
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func scanHealth(v []interface{}) (Health, error) {
+	var h Health
+	if err := redis.ScanStruct(v, &h); err != nil {
+		return Health{}, err
+	}
+	h.Dead = h.ConsecutiveFailures >= deadFailureCount
+	return h, nil
+}
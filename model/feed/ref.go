@@ -0,0 +1,104 @@
+package feed
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ItemRef locates a feed item by its numeric reference.
+type ItemRef struct {
+	SourceID string
+	FeedID   string
+}
+
+// Assigns increasing numeric references to the given members, return the references in the same
+// order. Members already assigned keep theirs. KEYS are the member to reference hash, the reference
+// to member hash and the counter; ARGV[1] is prepended to members in the reverse mapping.
+var assignRefsScript = redis.NewScript(3, `
+local res = {}
+for i = 2, #ARGV do
+	local ref = redis.call('HGET', KEYS[1], ARGV[i])
+	if not ref then
+		ref = redis.call('INCR', KEYS[3])
+		redis.call('HSET', KEYS[1], ARGV[i], ref)
+		redis.call('HSET', KEYS[2], ref, ARGV[1] .. ARGV[i])
+	end
+	res[#res+1] = tonumber(ref)
+end
+return res
+`)
+
+// GetItemRefs returns numeric references of feed items of a source, which clients of the Google Reader
+// and Fever APIs expect as item IDs. References are assigned on first request in the given order, so
+// pass items oldest first to keep references increasing with time.
+func GetItemRefs(srcID string, feedIDs []string) ([]int64, error) {
+	return assignRefs(util.FormatItemRefsKey(), srcID+" ", feedIDs)
+}
+
+// GetSourceRefs similarly returns numeric references of feed sources.
+func GetSourceRefs(srcIDs []string) ([]int64, error) {
+	return assignRefs(util.FormatSourceRefsKey(), "", srcIDs)
+}
+
+func assignRefs(key, prefix string, members []string) (refs []int64, err error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	args := redis.Args{}.Add(key, util.FormatRefLookupKey(key), util.FormatRefCounterKey(key), prefix).AddFlat(members)
+	err = rs.Do(func(c redis.Conn) (err error) {
+		refs, err = redis.Int64s(assignRefsScript.Do(c, args...))
+		return
+	})
+	return
+}
+
+// LookupItemRefs resolves numeric references of feed items, unknown ones are left out.
+func LookupItemRefs(refs []int64) (map[int64]ItemRef, error) {
+	members, err := lookupRefs(util.FormatItemRefsKey(), refs)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]ItemRef, len(members))
+	for ref, member := range members {
+		if parts := strings.SplitN(member, " ", 2); len(parts) == 2 {
+			res[ref] = ItemRef{SourceID: parts[0], FeedID: parts[1]}
+		}
+	}
+	return res, nil
+}
+
+// LookupSourceRefs resolves numeric references of feed sources to their IDs, unknown ones are left out.
+func LookupSourceRefs(refs []int64) (map[int64]string, error) {
+	return lookupRefs(util.FormatSourceRefsKey(), refs)
+}
+
+func lookupRefs(key string, refs []int64) (map[int64]string, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	var members []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		members, err = redis.Strings(c.Do("HMGET", redis.Args{}.Add(util.FormatRefLookupKey(key)).AddFlat(refs)...))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]string, len(refs))
+	for i, member := range members {
+		if member != "" {
+			res[refs[i]] = member
+		}
+	}
+	return res, nil
+}
+
+// ParseRef parses a numeric reference in decimal, return false if invalid.
+func ParseRef(s string) (int64, bool) {
+	ref, err := strconv.ParseInt(s, 10, 64)
+	return ref, err == nil && ref > 0
+}
@@ -0,0 +1,37 @@
+package user
+
+import (
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// CreateAccount adds a local account with the given password hash, return ErrExists if the
+// username is taken.
+func CreateAccount(username, passwordHash string) error {
+	var created bool
+	err := rs.Do(func(c redis.Conn) (err error) {
+		created, err = redis.Bool(c.Do("HSETNX", util.FormatAccountsKey(), username, passwordHash))
+		return
+	})
+	if err != nil {
+		return err
+	} else if !created {
+		return ErrExists
+	}
+	return nil
+}
+
+// GetAccountPasswordHash retrieves the password hash of a local account, return ErrNotFound if no
+// such account.
+func GetAccountPasswordHash(username string) (string, error) {
+	var hash string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		hash, err = redis.String(c.Do("HGET", util.FormatAccountsKey(), username))
+		return
+	})
+	if err == redis.ErrNil {
+		return "", ErrNotFound
+	}
+	return hash, err
+}
@@ -0,0 +1,122 @@
+package user
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Frequencies of email digests.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestPrefs are a user's preferences of email digests of unread items. Serialized as JSON in the
+// hash of all users' preferences, so that the scheduler can go through them.
+type DigestPrefs struct {
+	Email     string `json:"email"`
+	Frequency string `json:"frequency"`
+	// Hour of the day in UTC digests are sent at.
+	Hour int `json:"hour"`
+	// Day of the week weekly digests are sent on, 0 for Sunday.
+	Weekday int `json:"weekday"`
+	// Whether the items in a digest are marked read once sent.
+	MarkRead bool `json:"markRead"`
+}
+
+// GetDigestPrefs fetches the digest preferences of a user, return ErrNotFound if none are set.
+func GetDigestPrefs(user string) (DigestPrefs, error) {
+	var packet []byte
+	err := rs.Do(func(c redis.Conn) (err error) {
+		packet, err = redis.Bytes(c.Do("HGET", util.FormatDigestPrefsKey(), user))
+		return
+	})
+	if err == redis.ErrNil {
+		return DigestPrefs{}, ErrNotFound
+	} else if err != nil {
+		return DigestPrefs{}, err
+	}
+
+	var prefs DigestPrefs
+	if err := json.Unmarshal(packet, &prefs); err != nil {
+		return DigestPrefs{}, err
+	}
+	return prefs, nil
+}
+
+// GetAllDigestPrefs returns the digest preferences of all users who have set them.
+func GetAllDigestPrefs() (map[string]DigestPrefs, error) {
+	var packets map[string]string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		packets, err = redis.StringMap(c.Do("HGETALL", util.FormatDigestPrefsKey()))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]DigestPrefs, len(packets))
+	for user, packet := range packets {
+		var prefs DigestPrefs
+		if err := json.Unmarshal([]byte(packet), &prefs); err != nil {
+			log.Printf("[e] Skipped malformed digest preferences of %s: %v\n", user, err)
+			continue
+		}
+		res[user] = prefs
+	}
+	return res, nil
+}
+
+// SetDigestPrefs overwrites the digest preferences of a user.
+func SetDigestPrefs(user string, prefs DigestPrefs) error {
+	packet, _ := json.Marshal(prefs)
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatDigestPrefsKey(), user, packet)
+		return err
+	})
+}
+
+// RemoveDigestPrefs stops sending digests to a user, return ErrNotFound if none are set.
+func RemoveDigestPrefs(user string) error {
+	var replies []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		c.Send("MULTI")
+		c.Send("HDEL", util.FormatDigestPrefsKey(), user)
+		c.Send("HDEL", util.FormatDigestSentKey(), user)
+		replies, err = redis.Values(c.Do("EXEC"))
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if deleted, _ := redis.Int64(replies[0], nil); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDigestSent returns when the last digest was sent to a user in Unix seconds, zero if never.
+func GetDigestSent(user string) (int64, error) {
+	var sent int64
+	err := rs.Do(func(c redis.Conn) (err error) {
+		sent, err = redis.Int64(c.Do("HGET", util.FormatDigestSentKey(), user))
+		return
+	})
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return sent, err
+}
+
+// SetDigestSent records when the last digest was sent to a user in Unix seconds.
+func SetDigestSent(user string, sent int64) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatDigestSentKey(), user, strconv.FormatInt(sent, 10))
+		return err
+	})
+}
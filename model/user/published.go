@@ -0,0 +1,154 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Views of a user's feed items that can be published.
+const (
+	PublishStarred      = "starred"
+	PublishKeyword      = "keyword"
+	PublishSubscription = "subscription"
+)
+
+// PublishedFeed is a view of a user's feed items re-published as a feed at a secret URL.
+// Serialized as JSON in the user's published feed hash, where only the hash of the token in the
+// URL is kept.
+type PublishedFeed struct {
+	PublishedID string `json:"id"`
+	// View of the items, one of "starred", "keyword" and "subscription".
+	View string `json:"view"`
+	// Feed source of the items of a "subscription" view.
+	SourceID string `json:"sourceId,omitempty"`
+	// Keyword a "keyword" view selects the items of all subscriptions by, in their title or keywords.
+	Keyword string `json:"keyword,omitempty"`
+	Title   string `json:"title"`
+	// Creation time in Unix seconds.
+	Created int64 `json:"created"`
+}
+
+// Stored form of PublishedFeed, which unlike the API form includes the hash of the token.
+type storedPublishedFeed struct {
+	PublishedFeed
+	Hash string `json:"hash"`
+}
+
+// What a published feed token maps to.
+type publishedFeedRef struct {
+	User        string `json:"user"`
+	PublishedID string `json:"id"`
+}
+
+// CreatePublishedFeed publishes a view of a user's feed items with a newly generated ID, return
+// the token of its URL which can't be retrieved later, together with the published feed.
+func CreatePublishedFeed(user string, pf PublishedFeed) (string, PublishedFeed, error) {
+	secret := make([]byte, 24)
+	id := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", PublishedFeed{}, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", PublishedFeed{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	pf.PublishedID = hex.EncodeToString(id)
+	pf.Created = time.Now().Unix()
+	stored := storedPublishedFeed{PublishedFeed: pf, Hash: hashAPIToken(token)}
+
+	packet, _ := json.Marshal(stored)
+	refPacket, _ := json.Marshal(publishedFeedRef{user, pf.PublishedID})
+	err := rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("HSET", util.FormatUserPublishedKey(user), pf.PublishedID, packet)
+		c.Send("SET", util.FormatPublishedTokenKey(stored.Hash), refPacket)
+		_, err := c.Do("EXEC")
+		return err
+	})
+	if err != nil {
+		return "", PublishedFeed{}, err
+	}
+	return token, pf, nil
+}
+
+// GetPublishedFeeds lists the published feeds of a user.
+func GetPublishedFeeds(user string) ([]PublishedFeed, error) {
+	var feeds []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		feeds, err = redis.Strings(c.Do("HVALS", util.FormatUserPublishedKey(user)))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]PublishedFeed, 0, len(feeds))
+	for _, packet := range feeds {
+		var pf storedPublishedFeed
+		// Assume no unmarshalling error.
+		json.Unmarshal([]byte(packet), &pf)
+		res = append(res, pf.PublishedFeed)
+	}
+	return res, nil
+}
+
+// RemovePublishedFeed unpublishes a feed of a user, return ErrNotFound if no such feed.
+func RemovePublishedFeed(user, publishedID string) error {
+	userPublishedKey := util.FormatUserPublishedKey(user)
+	return rs.Do(func(c redis.Conn) error {
+		packet, err := redis.Bytes(c.Do("HGET", userPublishedKey, publishedID))
+		if err == redis.ErrNil {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var pf storedPublishedFeed
+		if err := json.Unmarshal(packet, &pf); err != nil {
+			return err
+		}
+		c.Send("MULTI")
+		c.Send("HDEL", userPublishedKey, publishedID)
+		c.Send("DEL", util.FormatPublishedTokenKey(pf.Hash))
+		_, err = c.Do("EXEC")
+		return err
+	})
+}
+
+// LookupPublishedFeed returns the user and the published feed a token belongs to, return
+// ErrNotFound if the token is unknown.
+func LookupPublishedFeed(token string) (string, PublishedFeed, error) {
+	var refPacket, packet []byte
+	err := rs.Do(func(c redis.Conn) (err error) {
+		refPacket, err = redis.Bytes(c.Do("GET", util.FormatPublishedTokenKey(hashAPIToken(token))))
+		if err != nil {
+			return
+		}
+		var ref publishedFeedRef
+		if err = json.Unmarshal(refPacket, &ref); err != nil {
+			return
+		}
+		packet, err = redis.Bytes(c.Do("HGET", util.FormatUserPublishedKey(ref.User), ref.PublishedID))
+		return
+	})
+	if err == redis.ErrNil {
+		return "", PublishedFeed{}, ErrNotFound
+	} else if err != nil {
+		return "", PublishedFeed{}, err
+	}
+
+	var ref publishedFeedRef
+	var pf storedPublishedFeed
+	json.Unmarshal(refPacket, &ref)
+	if err := json.Unmarshal(packet, &pf); err != nil {
+		return "", PublishedFeed{}, err
+	}
+	return ref.User, pf.PublishedFeed, nil
+}
@@ -0,0 +1,32 @@
+package user
+
+import (
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// StarFeedItem marks a feed item of a feed source as starred by the user.
+func StarFeedItem(user, srcID, feedID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatUserStarredKey(user), feedID, srcID)
+		return err
+	})
+}
+
+// UnstarFeedItem removes the star of a feed item.
+func UnstarFeedItem(user, feedID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HDEL", util.FormatUserStarredKey(user), feedID)
+		return err
+	})
+}
+
+// GetStarredFeedIds returns the starred feed IDs of a user, mapped to their feed sources.
+func GetStarredFeedIds(user string) (starred map[string]string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		starred, err = redis.StringMap(c.Do("HGETALL", util.FormatUserStarredKey(user)))
+		return
+	})
+	return
+}
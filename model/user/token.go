@@ -0,0 +1,164 @@
+package user
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Prefix of personal access tokens, to make them recognizable e.g. by secret scanners.
+const apiTokenPrefix = "rk_"
+
+// APIToken describes a personal access token of a user. Serialized as JSON in the user's token hash,
+// where only the hash of the token itself is kept.
+type APIToken struct {
+	TokenID string `json:"id"`
+	Name    string `json:"name"`
+	// Creation time in Unix seconds.
+	Created int64 `json:"created"`
+	// Username Fever API clients log in with, together with the token as password. Empty if the
+	// token isn't usable with the Fever API.
+	FeverLogin string `json:"feverLogin,omitempty"`
+}
+
+// Stored form of APIToken, which unlike the API form includes the hash.
+type storedAPIToken struct {
+	APIToken
+	Hash string `json:"hash"`
+	// Fever API key, i.e. MD5 of "<fever login>:<token>", if usable with the Fever API.
+	FeverKey string `json:"feverKey,omitempty"`
+}
+
+// CreateAPIToken generates a new personal access token for a user, return the token itself which
+// can't be retrieved later, together with its description. If `feverLogin` isn't empty, the token
+// can also be used with the Fever API by logging in with that username.
+func CreateAPIToken(user, name, feverLogin string) (string, APIToken, error) {
+	secret := make([]byte, 32)
+	id := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIToken{}, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", APIToken{}, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	t := storedAPIToken{
+		APIToken: APIToken{
+			TokenID:    hex.EncodeToString(id),
+			Name:       name,
+			Created:    time.Now().Unix(),
+			FeverLogin: feverLogin,
+		},
+		Hash: hashAPIToken(token),
+	}
+	if feverLogin != "" {
+		t.FeverKey = FeverAPIKey(feverLogin, token)
+	}
+
+	tokenPacket, _ := json.Marshal(t)
+	err := rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("HSET", util.FormatUserTokensKey(user), t.TokenID, tokenPacket)
+		c.Send("SET", util.FormatAPITokenKey(t.Hash), user)
+		if t.FeverKey != "" {
+			c.Send("SET", util.FormatFeverKey(t.FeverKey), user)
+		}
+		_, err := c.Do("EXEC")
+		return err
+	})
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	return token, t.APIToken, nil
+}
+
+// GetAPITokens lists the personal access tokens of a user.
+func GetAPITokens(user string) ([]APIToken, error) {
+	var tokens []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		tokens, err = redis.Strings(c.Do("HVALS", util.FormatUserTokensKey(user)))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		var t storedAPIToken
+		// Assume no unmarshalling error.
+		json.Unmarshal([]byte(token), &t)
+		res = append(res, t.APIToken)
+	}
+	return res, nil
+}
+
+// RevokeAPIToken deletes a personal access token of a user, return ErrNotFound if no such token.
+func RevokeAPIToken(user, tokenID string) error {
+	userTokensKey := util.FormatUserTokensKey(user)
+	return rs.Do(func(c redis.Conn) error {
+		tokenPacket, err := redis.Bytes(c.Do("HGET", userTokensKey, tokenID))
+		if err == redis.ErrNil {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var t storedAPIToken
+		if err := json.Unmarshal(tokenPacket, &t); err != nil {
+			return err
+		}
+		c.Send("MULTI")
+		c.Send("HDEL", userTokensKey, tokenID)
+		c.Send("DEL", util.FormatAPITokenKey(t.Hash))
+		if t.FeverKey != "" {
+			c.Send("DEL", util.FormatFeverKey(t.FeverKey))
+		}
+		_, err = c.Do("EXEC")
+		return err
+	})
+}
+
+// LookupAPIToken returns the user a personal access token belongs to, return ErrNotFound if the
+// token is unknown.
+func LookupAPIToken(token string) (string, error) {
+	return lookupUser(util.FormatAPITokenKey(hashAPIToken(token)))
+}
+
+// LookupFeverKey returns the user a Fever API key belongs to, return ErrNotFound if the key is unknown.
+func LookupFeverKey(apiKey string) (string, error) {
+	return lookupUser(util.FormatFeverKey(strings.ToLower(apiKey)))
+}
+
+func lookupUser(key string) (string, error) {
+	var user string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		user, err = redis.String(c.Do("GET", key))
+		return
+	})
+	if err == redis.ErrNil {
+		return "", ErrNotFound
+	}
+	return user, err
+}
+
+// FeverAPIKey computes the key Fever API clients send, as defined by the Fever API.
+func FeverAPIKey(login, password string) string {
+	sum := md5.Sum([]byte(login + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// Tokens are random with enough entropy, so a plain (unsalted) hash suffices and allows lookup.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
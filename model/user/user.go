@@ -2,7 +2,7 @@ package user
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 
 	"github.com/edfward/readkey/libstore"
 	"github.com/edfward/readkey/model/feed"
@@ -11,6 +11,19 @@ import (
 	"github.com/garyburd/redigo/redis"
 )
 
+// Sort orders allowed for a subscription's default sort.
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+)
+
+var (
+	// ErrNotFound is returned when the requested record doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when the record to add already exists.
+	ErrExists = errors.New("already exists")
+)
+
 var rs libstore.RedisStrore
 
 // Setup must be called before other functions to configure the Redis store.
@@ -18,145 +31,264 @@ func Setup(store libstore.RedisStrore) {
 	rs = store
 }
 
-// GetFeedSubscriptions fetches all subscribed feed sources of a user.
-func GetFeedSubscriptions(user string) []feed.Source {
-	c := rs.GetConnection()
-	defer c.Close()
+// Subscription is a user's view of a subscribed feed source. Serialized as JSON in the
+// user's subscription hash; it embeds the source so records stored before per-user
+// settings existed still decode.
+type Subscription struct {
+	feed.Source
+	// Display title chosen by the user, empty to use the source's own title.
+	CustomTitle string               `json:"customTitle,omitempty"`
+	Settings    SubscriptionSettings `json:"settings"`
+}
 
-	userSubKey := util.FormatUserSubsKey(user)
-	srcs, err := redis.Strings(c.Do("HVALS", userSubKey))
+// SubscriptionSettings keeps per-subscription preferences of a user.
+type SubscriptionSettings struct {
+	Notify      bool   `json:"notify"`
+	FullContent bool   `json:"fullContent"`
+	DefaultSort string `json:"defaultSort,omitempty"`
+	HideInRiver bool   `json:"hideInRiver"`
+	// Summarize new items of the source, which is done for all of its subscribers if any asks.
+	Summaries bool `json:"summaries"`
+}
+
+// GetFeedSubscriptions fetches all subscribed feed sources of a user.
+func GetFeedSubscriptions(user string) ([]Subscription, error) {
+	var srcs []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		srcs, err = redis.Strings(c.Do("HVALS", util.FormatUserSubsKey(user)))
+		return
+	})
 	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get subscribed feed sources.\n")
-		return nil
+		return nil, err
 	}
 
-	var fs feed.Source
-	res := make([]feed.Source, 0, len(srcs))
+	res := make([]Subscription, 0, len(srcs))
 	for _, src := range srcs {
+		var sub Subscription
 		// Assume no unmarshalling error.
-		json.Unmarshal([]byte(src), &fs)
-		res = append(res, fs)
+		json.Unmarshal([]byte(src), &sub)
+		res = append(res, sub)
 	}
-	return res
+	return res, nil
 }
 
-// AppendFeedSubscription tries to add a subscription to a user, return false if already exists (or error).
-func AppendFeedSubscription(user string, src feed.Source) bool {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	userSubKey := util.FormatUserSubsKey(user)
+// GetFeedSubscription fetches a single subscription of a user, return ErrNotFound if not subscribed.
+func GetFeedSubscription(user, srcID string) (Subscription, error) {
+	var packet []byte
+	err := rs.Do(func(c redis.Conn) (err error) {
+		packet, err = redis.Bytes(c.Do("HGET", util.FormatUserSubsKey(user), srcID))
+		return
+	})
+	if err == redis.ErrNil {
+		return Subscription{}, ErrNotFound
+	} else if err != nil {
+		return Subscription{}, err
+	}
 
-	exists, err := redis.Bool(c.Do("HEXISTS", userSubKey, src.SourceID))
-	if err != nil {
-		log.Printf("[e] Failed to check whether feed subscription exists for a user.\n")
-		return false
-	} else if exists {
-		return false
+	var sub Subscription
+	if err := json.Unmarshal(packet, &sub); err != nil {
+		return Subscription{}, err
 	}
+	return sub, nil
+}
 
-	srcPacket, _ := json.Marshal(src)
-	if _, err := c.Do("HSET", userSubKey, src.SourceID, srcPacket); err != nil {
-		// TODO: Detailed log.
-		log.Printf("[e] Failed to append feed subscription to user.\n")
+// Subscribes a user to a feed source: adds the subscription unless it exists, then the user to the
+// source's subscribers and the latest feed IDs to the user's unread ones. KEYS are the user's
+// subscriptions, the source's subscribers, the user's unread feed IDs and the source's latest feed
+// IDs; ARGV are the source ID, the subscription, the user and the number of latest feed IDs.
+var subscribeScript = redis.NewScript(4, `
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('SADD', KEYS[2], ARGV[3])
+local latest = redis.call('LRANGE', KEYS[4], 0, tonumber(ARGV[4]) - 1)
+for _, feedID in ipairs(latest) do
+	redis.call('SADD', KEYS[3], feedID)
+end
+return 1
+`)
+
+// Subscribe adds a subscription of a feed source to a user, registering the user as subscriber of
+// the source and marking its latest feed items unread at once. Return ErrExists if already subscribed.
+func Subscribe(user string, src feed.Source) error {
+	subPacket, _ := json.Marshal(Subscription{Source: src})
+	args := redis.Args{}.Add(
+		util.FormatUserSubsKey(user),
+		util.FormatSubscriberKey(src.SourceID),
+		util.FormatUserUnreadKey(user, src.SourceID),
+		util.FormatLatestFeedsKey(src.SourceID),
+		src.SourceID, subPacket, user, feed.InitUnreadFeedCount)
+	var added bool
+	err := rs.Do(func(c redis.Conn) (err error) {
+		added, err = redis.Bool(subscribeScript.Do(c, args...))
+		return
+	})
+	if err != nil {
+		return err
+	} else if !added {
+		return ErrExists
 	}
-	return true
+	return nil
 }
 
-// RemoveFeedSubscription removes the subscribed feed source, return true if successful.
-func RemoveFeedSubscription(user, srcID string) bool {
-	c := rs.GetConnection()
-	defer c.Close()
+// Attempts of a subscription update conflicting with others, e.g. a user's changes and the refresh of
+// the source by its feed handler at once.
+const maxUpdateAttempts = 5
 
+var errUpdateConflict = errors.New("subscription kept changing while updated")
+
+// UpdateFeedSubscription reads, changes by `update` and writes back a subscription in a transaction,
+// retried if the user's subscriptions changed meanwhile, and returns the updated subscription.
+// Return ErrNotFound if the user doesn't subscribe to the source, or unsubscribed meanwhile.
+func UpdateFeedSubscription(user, srcID string, update func(sub *Subscription)) (Subscription, error) {
 	userSubKey := util.FormatUserSubsKey(user)
-	deleted, err := redis.Int64(c.Do("HDEL", userSubKey, srcID))
-	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to remove subscription.\n")
-		return false
+	var sub Subscription
+	err := rs.Do(func(c redis.Conn) error {
+		for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+			if _, err := c.Do("WATCH", userSubKey); err != nil {
+				return err
+			}
+			packet, err := redis.Bytes(c.Do("HGET", userSubKey, srcID))
+			if err == redis.ErrNil {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			sub = Subscription{}
+			if err := json.Unmarshal(packet, &sub); err != nil {
+				return err
+			}
+			update(&sub)
+			subPacket, _ := json.Marshal(sub)
+			c.Send("MULTI")
+			c.Send("HSET", userSubKey, srcID, subPacket)
+			replies, err := c.Do("EXEC")
+			if err != nil {
+				return err
+			} else if replies != nil {
+				return nil
+			}
+			// Aborted, as the user's subscriptions changed after WATCH.
+		}
+		return errUpdateConflict
+	})
+	return sub, err
+}
+
+// RefreshFeedSubscription replaces the source record kept in a user's subscription with the
+// updated one, leaving the user's title override and settings intact. No-op if the user
+// doesn't subscribe to the source.
+func RefreshFeedSubscription(user string, src feed.Source) error {
+	_, err := UpdateFeedSubscription(user, src.SourceID, func(sub *Subscription) {
+		sub.Source = src
+	})
+	if err == ErrNotFound {
+		return nil
 	}
-	return deleted == 1
+	return err
 }
 
-// GetUnreadFeedIds returns a list of unread feed IDs.
-func GetUnreadFeedIds(user, srcID string) []string {
-	c := rs.GetConnection()
-	defer c.Close()
+// MergeFeedSubscription moves a user's subscription of a duplicate feed source over to the source
+// it's merged into, keeping the title override, settings and unread items, and registers the user
+// as subscriber of `into`.
+func MergeFeedSubscription(user, fromSrcID string, into feed.Source) error {
+	sub, err := GetFeedSubscription(user, fromSrcID)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	sub.Source = into
 
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	unreadIds, err := redis.Strings(c.Do("SMEMBERS", unreadKey))
+	userSubKey := util.FormatUserSubsKey(user)
+	fromUnreadKey := util.FormatUserUnreadKey(user, fromSrcID)
+	intoUnreadKey := util.FormatUserUnreadKey(user, into.SourceID)
+	subPacket, _ := json.Marshal(sub)
+	return rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("HSETNX", userSubKey, into.SourceID, subPacket)
+		c.Send("HDEL", userSubKey, fromSrcID)
+		c.Send("SADD", util.FormatSubscriberKey(into.SourceID), user)
+		c.Send("SREM", util.FormatSubscriberKey(fromSrcID), user)
+		c.Send("SUNIONSTORE", intoUnreadKey, intoUnreadKey, fromUnreadKey)
+		c.Send("DEL", fromUnreadKey)
+		_, err := c.Do("EXEC")
+		return err
+	})
+}
+
+// RemoveFeedSubscription removes the subscribed feed source together with the user's unread feed
+// IDs of it, and the user from its subscribers. Return ErrNotFound if not subscribed.
+func RemoveFeedSubscription(user, srcID string) error {
+	var replies []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		c.Send("MULTI")
+		c.Send("HDEL", util.FormatUserSubsKey(user), srcID)
+		c.Send("SREM", util.FormatSubscriberKey(srcID), user)
+		c.Send("DEL", util.FormatUserUnreadKey(user, srcID))
+		replies, err = redis.Values(c.Do("EXEC"))
+		return
+	})
 	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get unread feed IDs.\n")
-		return nil
+		return err
 	}
+	if deleted, _ := redis.Int64(replies[0], nil); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	return unreadIds
+// GetUnreadFeedIds returns a list of unread feed IDs.
+func GetUnreadFeedIds(user, srcID string) (unreadIds []string, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		unreadIds, err = redis.Strings(c.Do("SMEMBERS", util.FormatUserUnreadKey(user, srcID)))
+		return
+	})
+	return
 }
 
 // AppendUnreadFeedItemID adds an unread feed ID to the user w.r.t. a feed source.
-func AppendUnreadFeedItemID(user, srcID, feedID string) {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	if _, err := c.Do("SADD", unreadKey, feedID); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to add an unread feed ID.\n")
-	}
+func AppendUnreadFeedItemID(user, srcID, feedID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("SADD", util.FormatUserUnreadKey(user, srcID), feedID)
+		return err
+	})
 }
 
 // RemoveUnreadFeedItemID similarly removes an unread feed ID.
-func RemoveUnreadFeedItemID(user, srcID, feedID string) {
-	c := rs.GetConnection()
-	defer c.Close()
+func RemoveUnreadFeedItemID(user, srcID, feedID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("SREM", util.FormatUserUnreadKey(user, srcID), feedID)
+		return err
+	})
+}
 
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	if _, err := c.Do("SREM", unreadKey, feedID); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to remove an unread feed ID.\n")
+// RemoveUnreadFeedItemIDs removes several unread feed IDs at once.
+func RemoveUnreadFeedItemIDs(user, srcID string, feedIDs []string) error {
+	if len(feedIDs) == 0 {
+		return nil
 	}
+	unreadKey := util.FormatUserUnreadKey(user, srcID)
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("SREM", redis.Args{}.Add(unreadKey).AddFlat(feedIDs)...)
+		return err
+	})
 }
 
 // RemoveAllUnreadFeedItem removes all unread feeds.
-func RemoveAllUnreadFeedItem(user, srcID string) {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	if _, err := c.Do("DEL", unreadKey); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to remove all unread feeds.\n")
-	}
+func RemoveAllUnreadFeedItem(user, srcID string) error {
+	return rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("DEL", util.FormatUserUnreadKey(user, srcID))
+		return err
+	})
 }
 
 // GetUnreadFeedCount returns the count of unread feeds of the user w.r.t. a feed source.
 // TODO: Maybe should be done when retrieving subscriptions?
-func GetUnreadFeedCount(user, srcID string) int64 {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	cnt, err := redis.Int64(c.Do("SCARD", unreadKey))
-	if err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to get unread feed count.\n")
-	}
-	return cnt
-}
-
-// InitUserUnreadQueue simply copies feed IDs from the corresponding feed source's latest
-// feed streams (which may have duplicates).
-func InitUserUnreadQueue(user, srcID string) {
-	c := rs.GetConnection()
-	defer c.Close()
-
-	unreadKey := util.FormatUserUnreadKey(user, srcID)
-	latestFeedIds := feed.GetLatestItemIdsFromSource(srcID)
-
-	if _, err := c.Do("SADD", redis.Args{}.Add(unreadKey).AddFlat(latestFeedIds)...); err != nil {
-		// TODO: Detailed log & retry.
-		log.Printf("[e] Failed to init unread queue for user.\n")
-	}
+func GetUnreadFeedCount(user, srcID string) (cnt int64, err error) {
+	err = rs.Do(func(c redis.Conn) (err error) {
+		cnt, err = redis.Int64(c.Do("SCARD", util.FormatUserUnreadKey(user, srcID)))
+		return
+	})
+	return
 }
@@ -0,0 +1,181 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/util"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Number of latest deliveries kept in the log of a webhook.
+const webhookLogCapacity = 50
+
+// Webhook is a URL new feed items of a user's subscriptions are posted to. Serialized as JSON in the
+// user's webhook hash.
+type Webhook struct {
+	WebhookID string `json:"id"`
+	URL       string `json:"url"`
+	// Only items of this feed source are posted if set.
+	SourceID string `json:"sourceId,omitempty"`
+	// Only items whose title or keywords contain it, ignoring case, are posted if set.
+	Keyword string `json:"keyword,omitempty"`
+	// Creation time in Unix seconds.
+	Created int64 `json:"created"`
+}
+
+// Stored form of Webhook, which unlike the API form includes the secret payloads are signed with.
+type storedWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery records an attempt to post to a webhook.
+type WebhookDelivery struct {
+	DeliveryID string `json:"id"`
+	// Kind of the payload, "item" for a new feed item or "ping" for a test delivery.
+	Event   string `json:"event"`
+	Attempt int    `json:"attempt"`
+	// Time of the attempt in Unix seconds.
+	Time int64 `json:"time"`
+	// HTTP status code of the response, zero if none was received.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Whether the delivery will be attempted again.
+	Retrying bool `json:"retrying"`
+}
+
+// Matches tells whether a new feed item of a feed source is to be posted to the webhook.
+func (w Webhook) Matches(srcID string, entry feed.ItemEntry) bool {
+	if w.SourceID != "" && w.SourceID != srcID {
+		return false
+	}
+	return w.Keyword == "" || entry.MatchesKeyword(w.Keyword)
+}
+
+// CreateWebhook adds a webhook to a user with a newly generated ID, return the secret payloads are
+// signed with together with the webhook.
+func CreateWebhook(user string, w Webhook) (string, Webhook, error) {
+	secret := make([]byte, 32)
+	id := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Webhook{}, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", Webhook{}, err
+	}
+	w.WebhookID = hex.EncodeToString(id)
+	w.Created = time.Now().Unix()
+	stored := storedWebhook{Webhook: w, Secret: hex.EncodeToString(secret)}
+
+	packet, _ := json.Marshal(stored)
+	err := rs.Do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", util.FormatUserWebhooksKey(user), w.WebhookID, packet)
+		return err
+	})
+	if err != nil {
+		return "", Webhook{}, err
+	}
+	return stored.Secret, w, nil
+}
+
+// GetWebhooks lists the webhooks of a user.
+func GetWebhooks(user string) ([]Webhook, error) {
+	var webhooks []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		webhooks, err = redis.Strings(c.Do("HVALS", util.FormatUserWebhooksKey(user)))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		var w storedWebhook
+		// Assume no unmarshalling error.
+		json.Unmarshal([]byte(webhook), &w)
+		res = append(res, w.Webhook)
+	}
+	return res, nil
+}
+
+// GetWebhook fetches a webhook of a user together with its secret, return ErrNotFound if no such
+// webhook.
+func GetWebhook(user, webhookID string) (Webhook, string, error) {
+	var packet []byte
+	err := rs.Do(func(c redis.Conn) (err error) {
+		packet, err = redis.Bytes(c.Do("HGET", util.FormatUserWebhooksKey(user), webhookID))
+		return
+	})
+	if err == redis.ErrNil {
+		return Webhook{}, "", ErrNotFound
+	} else if err != nil {
+		return Webhook{}, "", err
+	}
+
+	var w storedWebhook
+	if err := json.Unmarshal(packet, &w); err != nil {
+		return Webhook{}, "", err
+	}
+	return w.Webhook, w.Secret, nil
+}
+
+// RemoveWebhook deletes a webhook of a user with its delivery log, return ErrNotFound if no such
+// webhook. Deliveries still queued are dropped.
+func RemoveWebhook(user, webhookID string) error {
+	var replies []interface{}
+	err := rs.Do(func(c redis.Conn) (err error) {
+		c.Send("MULTI")
+		c.Send("HDEL", util.FormatUserWebhooksKey(user), webhookID)
+		c.Send("DEL", util.FormatWebhookLogKey(user, webhookID))
+		replies, err = redis.Values(c.Do("EXEC"))
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if deleted, _ := redis.Int64(replies[0], nil); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AppendWebhookDelivery records a delivery attempt in the log of a webhook, which keeps the latest
+// ones only.
+func AppendWebhookDelivery(user, webhookID string, d WebhookDelivery) error {
+	logKey := util.FormatWebhookLogKey(user, webhookID)
+	packet, _ := json.Marshal(d)
+	return rs.Do(func(c redis.Conn) error {
+		c.Send("MULTI")
+		c.Send("LPUSH", logKey, packet)
+		c.Send("LTRIM", logKey, 0, webhookLogCapacity-1)
+		_, err := c.Do("EXEC")
+		return err
+	})
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts of a webhook, newest first.
+func GetWebhookDeliveries(user, webhookID string) ([]WebhookDelivery, error) {
+	var deliveries []string
+	err := rs.Do(func(c redis.Conn) (err error) {
+		deliveries, err = redis.Strings(c.Do("LRANGE", util.FormatWebhookLogKey(user, webhookID), 0, -1))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		var d WebhookDelivery
+		// Assume no unmarshalling error.
+		json.Unmarshal([]byte(delivery), &d)
+		res = append(res, d)
+	}
+	return res, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build the OpenAPI document of the routes, describing request and response bodies by the Go types
+// of their samples so that the document can't drift from the handlers.
+func buildOpenAPI(prefix string, routes []apiRoute) gin.H {
+	sb := &schemaBuilder{schemas: make(map[string]interface{}), names: make(map[string]string)}
+	errorSchema := sb.schemaOf(reflect.TypeOf(apiErrorResponse{}))
+
+	paths := make(map[string]gin.H)
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+		op := gin.H{"summary": route.Summary}
+
+		queryNames := make([]string, 0, len(route.Query))
+		for name := range route.Query {
+			queryNames = append(queryNames, name)
+		}
+		sort.Strings(queryNames)
+		parameters := make([]gin.H, 0, len(params)+len(queryNames))
+		for _, name := range params {
+			parameters = append(parameters, gin.H{
+				"name": name, "in": "path", "required": true,
+				"schema": gin.H{"type": "string"},
+			})
+		}
+		for _, name := range queryNames {
+			parameters = append(parameters, gin.H{
+				"name": name, "in": "query", "description": route.Query[name],
+				"schema": gin.H{"type": "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			op["parameters"] = parameters
+		}
+
+		if route.Request != nil {
+			op["requestBody"] = gin.H{
+				"required": true,
+				"content":  gin.H{"application/json": gin.H{"schema": sb.schemaOf(reflect.TypeOf(route.Request))}},
+			}
+		}
+
+		success := gin.H{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			success["content"] = gin.H{"application/json": gin.H{"schema": gin.H{
+				"type":       "object",
+				"properties": gin.H{"data": sb.schemaOf(reflect.TypeOf(route.Response))},
+				"required":   []string{"data"},
+			}}}
+		}
+		responses := gin.H{strconv.Itoa(route.Status): success}
+		// Every route requires authentication and may fail due to storage errors.
+		for _, status := range append([]int{401, 500}, route.Errors...) {
+			responses[strconv.Itoa(status)] = gin.H{
+				"description": http.StatusText(status),
+				"content":     gin.H{"application/json": gin.H{"schema": errorSchema}},
+			}
+		}
+		op["responses"] = responses
+
+		if paths[path] == nil {
+			paths[path] = gin.H{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "ReadKey API", "version": "v1"},
+		"servers": []gin.H{{"url": prefix}},
+		"paths":   paths,
+		"components": gin.H{
+			"schemas": sb.schemas,
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{"type": "http", "scheme": "bearer", "description": "Personal access token"},
+				"session":    gin.H{"type": "apiKey", "in": "cookie", "name": "readkey-session"},
+			},
+		},
+		"security": []gin.H{{"bearerAuth": []string{}}, {"session": []string{}}},
+	}
+}
+
+// Convert a gin style path such as "/items/:id" to the OpenAPI style "/items/{id}", return the
+// path parameters as well.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// Builds JSON schemas of Go types, named struct types become component schemas.
+type schemaBuilder struct {
+	schemas map[string]interface{}
+	// Component names by package path and name of the types, as types of different packages may
+	// share a name.
+	names map[string]string
+}
+
+func (sb *schemaBuilder) schemaOf(t reflect.Type) gin.H {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sb.schemaOf(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sb.structSchema(t)
+		}
+		key := t.PkgPath() + "." + t.Name()
+		name, ok := sb.names[key]
+		if !ok {
+			name = sb.componentName(t)
+			sb.names[key] = name
+			// Placeholder against recursive types.
+			sb.schemas[name] = gin.H{}
+			sb.schemas[name] = sb.structSchema(t)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return gin.H{"type": "string", "format": "byte"}
+		}
+		return gin.H{"type": "array", "items": sb.schemaOf(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": sb.schemaOf(t.Elem())}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	}
+	return gin.H{}
+}
+
+// Name of the component schema of a type not named yet, qualified by the package unless it's the
+// API's own, e.g. "feed.ItemEntry" or "subscriptionView", and numbered if still taken.
+func (sb *schemaBuilder) componentName(t reflect.Type) string {
+	qualified := t.Name()
+	if t.PkgPath() != reflect.TypeOf(apiRoute{}).PkgPath() {
+		qualified = path.Base(t.PkgPath()) + "." + t.Name()
+	}
+	name := qualified
+	for i := 2; sb.schemas[name] != nil; i++ {
+		name = qualified + strconv.Itoa(i)
+	}
+	return name
+}
+
+// Schema of a struct as encoding/json serializes it, flattening embedded structs.
+func (sb *schemaBuilder) structSchema(t reflect.Type) gin.H {
+	properties := gin.H{}
+	var required []string
+	sb.addFields(t, properties, &required)
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (sb *schemaBuilder) addFields(t reflect.Type, properties gin.H, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			sb.addFields(field.Type, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = sb.schemaOf(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
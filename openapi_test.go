@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/edfward/readkey/model/feed"
+)
+
+// Decode the document as clients do.
+func decodeOpenAPI(t *testing.T, routes []apiRoute) map[string]interface{} {
+	packet, err := json.Marshal(buildOpenAPI(apiV1Prefix, routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(packet, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// Collect the schema references within a part of the document.
+func collectRefs(v interface{}, refs map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, elem := range v {
+			if ref, ok := elem.(string); ok && k == "$ref" {
+				refs[ref] = true
+			}
+			collectRefs(elem, refs)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			collectRefs(elem, refs)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	doc := decodeOpenAPI(t, apiV1Routes)
+
+	paths := doc["paths"].(map[string]interface{})
+	for _, route := range apiV1Routes {
+		path, _ := openAPIPath(route.Path)
+		item, _ := paths[path].(map[string]interface{})
+		if item[strings.ToLower(route.Method)] == nil {
+			t.Errorf("no operation %s %s", route.Method, path)
+		}
+	}
+	item, _ := paths["/subscriptions/{id}"].(map[string]interface{})
+	op, _ := item["patch"].(map[string]interface{})
+	if params, _ := op["parameters"].([]interface{}); len(params) != 1 || params[0].(map[string]interface{})["name"] != "id" {
+		t.Errorf("PATCH /subscriptions/{id} has parameters %v, want id", op["parameters"])
+	}
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"apiErrorResponse", "subscriptionView", "feed.ItemEntry", "feed.Item"} {
+		if schemas[name] == nil {
+			t.Errorf("no schema %s", name)
+		}
+	}
+	refs := make(map[string]bool)
+	collectRefs(doc, refs)
+	for ref := range refs {
+		if name := strings.TrimPrefix(ref, "#/components/schemas/"); schemas[name] == nil {
+			t.Errorf("reference %s to no schema", ref)
+		}
+	}
+	entry, _ := schemas["feed.ItemEntry"].(map[string]interface{})
+	properties, _ := entry["properties"].(map[string]interface{})
+	if title, _ := properties["title"].(map[string]interface{}); title["type"] != "string" {
+		t.Errorf("feed.ItemEntry has title %v, want a string", properties["title"])
+	}
+}
+
+// Source of the test routes, named like feed.Source.
+type Source struct {
+	Name string `json:"name"`
+}
+
+func TestOpenAPISchemasOfSameName(t *testing.T) {
+	doc := decodeOpenAPI(t, []apiRoute{
+		{Method: "POST", Path: "/sources", Summary: "Test", Status: 200, Request: Source{}, Response: feed.Source{}},
+	})
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	local, _ := schemas["Source"].(map[string]interface{})
+	if properties, _ := local["properties"].(map[string]interface{}); properties["name"] == nil {
+		t.Errorf("schema Source is %v, want the test's", local)
+	}
+	src, _ := schemas["feed.Source"].(map[string]interface{})
+	if properties, _ := src["properties"].(map[string]interface{}); properties["id"] == nil {
+		t.Errorf("schema feed.Source is %v, want feed.Source's", src)
+	}
+
+	op := doc["paths"].(map[string]interface{})["/sources"].(map[string]interface{})["post"]
+	refs := make(map[string]bool)
+	collectRefs(op, refs)
+	for _, ref := range []string{"#/components/schemas/Source", "#/components/schemas/feed.Source"} {
+		if !refs[ref] {
+			t.Errorf("operation lacks reference %s", ref)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package publish re-publishes views of a user's feed items, i.e. the starred items, those of a
+// subscription or those matching a keyword, as Atom or RSS feeds at secret URLs, so that they can
+// be consumed by other tools. Anyone with the URL can read the feed, until it's unpublished.
+package publish
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path published feeds are served under, followed by their token.
+const PathPrefix = "/published/"
+
+// Entries in a published feed at most, the latest ones.
+const maxEntries = 50
+
+// URL the server is reached at, e.g. "https://readkey.example.com", empty if not configured.
+var baseURL *url.URL
+
+// Mount registers the unauthenticated endpoint serving published feeds, as Atom by default or as
+// RSS with "?format=rss". Feed URLs and the IDs of feeds and entries are based on `base`, the URL
+// the server is reached at; without it they follow the host of each request, so IDs change with it.
+func Mount(r *gin.Engine, base string) {
+	if base != "" {
+		u, err := url.Parse(strings.TrimSuffix(base, "/"))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("[e] Invalid base URL %q, expecting e.g. https://readkey.example.com\n", base)
+		}
+		baseURL = u
+	} else {
+		log.Printf("[w] Base URL isn't set, published feed IDs follow the host of requests.\n")
+	}
+	r.GET(PathPrefix+":token", serve)
+}
+
+// URL returns the absolute URL a published feed is served at, as requested through `c` unless the
+// base URL is configured.
+func URL(c *gin.Context, token string) string {
+	if baseURL != nil {
+		return baseURL.String() + PathPrefix + token
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + PathPrefix + token
+}
+
+// Host the tag URIs of published feeds are minted by.
+func tagHost(c *gin.Context) string {
+	host := c.Request.Host
+	if baseURL != nil {
+		host = baseURL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// Tag URI (RFC 4151) minted by the host on the date, of e.g. "item/<id>".
+func tagURI(host string, date time.Time, specific string) string {
+	return "tag:" + strings.ToLower(host) + "," + date.UTC().Format("2006-01-02") + ":" + specific
+}
+
+func serve(c *gin.Context) {
+	token := c.Param("token")
+	username, pf, err := user.LookupPublishedFeed(token)
+	if err == user.ErrNotFound {
+		c.String(404, "feed not found")
+		return
+	} else if err != nil {
+		log.Printf("[e] Failed to look up published feed: %v\n", err)
+		c.String(500, "storage error")
+		return
+	}
+	f, err := build(username, pf, tagHost(c), URL(c, token))
+	if err != nil {
+		log.Printf("[e] Failed to build published feed %s of %s: %v\n", pf.PublishedID, username, err)
+		c.String(500, "storage error")
+		return
+	}
+
+	write, contentType := WriteAtom, "application/atom+xml; charset=utf-8"
+	if c.Query("format") == "rss" {
+		write, contentType = WriteRSS, "application/rss+xml; charset=utf-8"
+	}
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Writer.WriteHeader(200)
+	if err := write(c.Writer, f); err != nil {
+		log.Printf("[e] Failed to write published feed %s of %s: %v\n", pf.PublishedID, username, err)
+	}
+}
+
+// Entry of the view with what's needed to sort and select it.
+type viewEntry struct {
+	feed.ItemEntry
+	Source    user.Subscription
+	Published time.Time
+}
+
+// Build the feed of the latest items in the view. IDs are tag URIs of the host, dated by when the
+// feed was published.
+func build(username string, pf user.PublishedFeed, host, self string) (Feed, error) {
+	entries, err := collect(username, pf)
+	if err != nil {
+		return Feed{}, err
+	}
+	// Latest first, those of unknown publication time last.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Published.After(entries[j].Published)
+	})
+	if len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+
+	created := time.Unix(pf.Created, 0).UTC()
+	f := Feed{
+		ID:      tagURI(host, created, "published/"+pf.PublishedID),
+		Title:   pf.Title,
+		Self:    self,
+		Updated: created,
+	}
+	for _, ve := range entries {
+		// Items missing content are still listed, as their entries exist.
+		item, err := feed.GetItem(ve.FeedID)
+		if err != nil && err != feed.ErrNotFound {
+			return Feed{}, err
+		}
+		e := Entry{
+			ID:          tagURI(host, created, "item/"+ve.FeedID),
+			Title:       ve.Title,
+			Link:        item.Link,
+			Content:     item.Content,
+			Published:   ve.Published,
+			Updated:     ve.Published,
+			SourceID:    tagURI(host, created, "source/"+ve.Source.SourceID),
+			SourceTitle: ve.Source.Title,
+			SourceURL:   ve.Source.URL,
+			SourceLink:  ve.Source.Link,
+		}
+		if ve.Source.CustomTitle != "" {
+			e.SourceTitle = ve.Source.CustomTitle
+		}
+		if e.Updated.IsZero() {
+			// Keep it stable across requests.
+			e.Updated = created
+		}
+		for _, kw := range ve.Keywords {
+			e.Categories = append(e.Categories, kw.Text)
+		}
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f, nil
+}
+
+// Collect the entries in the view of the user's feed items. The items known of a subscription are
+// its latest ones.
+func collect(username string, pf user.PublishedFeed) ([]viewEntry, error) {
+	subs, err := user.GetFeedSubscriptions(username)
+	if err != nil {
+		return nil, err
+	}
+	var res []viewEntry
+	appendEntries := func(sub user.Subscription, feedIDs []string, match func(feed.ItemEntry) bool) error {
+		entries, err := feed.GetItemEntriesFromSource(sub.SourceID, feedIDs)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if match != nil && !match(entry) {
+				continue
+			}
+			published, _ := util.ParseFeedDate(entry.PubDate)
+			res = append(res, viewEntry{entry, sub, published})
+		}
+		return nil
+	}
+
+	switch pf.View {
+	case user.PublishStarred:
+		starred, err := user.GetStarredFeedIds(username)
+		if err != nil {
+			return nil, err
+		}
+		bySource := make(map[string][]string)
+		for feedID, srcID := range starred {
+			bySource[srcID] = append(bySource[srcID], feedID)
+		}
+		for _, sub := range subs {
+			if feedIDs := bySource[sub.SourceID]; len(feedIDs) > 0 {
+				if err := appendEntries(sub, feedIDs, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case user.PublishSubscription, user.PublishKeyword:
+		match := func(entry feed.ItemEntry) bool {
+			return entry.MatchesKeyword(pf.Keyword)
+		}
+		if pf.View == user.PublishSubscription {
+			match = nil
+		}
+		for _, sub := range subs {
+			if pf.View == user.PublishSubscription && sub.SourceID != pf.SourceID {
+				continue
+			}
+			feedIDs, err := feed.GetRecentItemIdsFromSource(sub.SourceID)
+			if err != nil {
+				return nil, err
+			}
+			if err := appendEntries(sub, feedIDs, match); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
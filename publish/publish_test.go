@@ -0,0 +1,47 @@
+package publish
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTagURI(t *testing.T) {
+	created := time.Date(2024, 3, 1, 23, 30, 0, 0, time.FixedZone("PST", -8*3600))
+	got := tagURI("ReadKey.example.com", created, "item/feed:abc")
+	// Dated in UTC, whatever the zone of the creation time.
+	want := "tag:readkey.example.com,2024-03-02:item/feed:abc"
+	if got != want {
+		t.Errorf("tagURI = %q, want %q", got, want)
+	}
+}
+
+func TestTagHostFollowsBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { baseURL = nil }()
+	newContext := func(host string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/published/secret", nil)
+		c.Request.Host = host
+		return c
+	}
+
+	baseURL = nil
+	if got := tagHost(newContext("localhost:8080")); got != "localhost" {
+		t.Errorf("tagHost without base URL = %q, want the request host", got)
+	}
+
+	baseURL, _ = url.Parse("https://readkey.example.com:8443")
+	for _, host := range []string{"localhost:8080", "10.0.0.1", "readkey.example.com"} {
+		c := newContext(host)
+		if got := tagHost(c); got != "readkey.example.com" {
+			t.Errorf("tagHost with Host %q = %q, want readkey.example.com", host, got)
+		}
+		if got, want := URL(c, "secret"), "https://readkey.example.com:8443/published/secret"; got != want {
+			t.Errorf("URL with Host %q = %q, want %q", host, got, want)
+		}
+	}
+}
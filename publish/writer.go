@@ -0,0 +1,210 @@
+package publish
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Feed to be written as Atom or RSS.
+type Feed struct {
+	// Globally unique and permanent ID such as a tag URI.
+	ID    string
+	Title string
+	// URL the feed itself is served at.
+	Self    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry of a feed.
+type Entry struct {
+	// Globally unique and permanent ID such as a tag URI.
+	ID    string
+	Title string
+	Link  string
+	// HTML content.
+	Content string
+	// Publication time, zero if unknown.
+	Published time.Time
+	Updated   time.Time
+	// Keywords of the entry.
+	Categories []string
+	// Feed the entry comes from, with the URLs of the feed and of its site.
+	SourceID    string
+	SourceTitle string
+	SourceURL   string
+	SourceLink  string
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+	Source     *atomSource    `xml:"source"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomSource struct {
+	ID    string     `xml:"id,omitempty"`
+	Title string     `xml:"title"`
+	Links []atomLink `xml:"link"`
+}
+
+// WriteAtom writes the feed as an Atom 1.0 document (RFC 4287).
+func WriteAtom(w io.Writer, f Feed) error {
+	af := atomFeed{
+		ID:        f.ID,
+		Title:     f.Title,
+		Updated:   f.Updated.UTC().Format(time.RFC3339),
+		Links:     []atomLink{{Rel: "self", Type: "application/atom+xml", Href: f.Self}},
+		Author:    atomPerson{"ReadKey"},
+		Generator: "ReadKey",
+	}
+	for _, e := range f.Entries {
+		ae := atomEntry{
+			ID:      e.ID,
+			Title:   e.Title,
+			Updated: e.Updated.UTC().Format(time.RFC3339),
+			Content: atomText{"html", e.Content},
+		}
+		if !e.Published.IsZero() {
+			ae.Published = e.Published.UTC().Format(time.RFC3339)
+		}
+		if e.Link != "" {
+			ae.Links = append(ae.Links, atomLink{Rel: "alternate", Type: "text/html", Href: e.Link})
+		}
+		for _, term := range e.Categories {
+			ae.Categories = append(ae.Categories, atomCategory{term})
+		}
+		if e.SourceTitle != "" {
+			ae.Source = &atomSource{ID: e.SourceID, Title: e.SourceTitle}
+			if e.SourceURL != "" {
+				ae.Source.Links = append(ae.Source.Links, atomLink{Rel: "self", Href: e.SourceURL})
+			}
+			if e.SourceLink != "" {
+				ae.Source.Links = append(ae.Source.Links, atomLink{Rel: "alternate", Type: "text/html", Href: e.SourceLink})
+			}
+		}
+		af.Entries = append(af.Entries, ae)
+	}
+	return writeXML(w, af)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Generator     string    `xml:"generator"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link,omitempty"`
+	GUID        rssGUID    `xml:"guid"`
+	PubDate     string     `xml:"pubDate,omitempty"`
+	Description string     `xml:"description"`
+	Categories  []string   `xml:"category"`
+	Source      *rssSource `xml:"source"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssSource struct {
+	URL   string `xml:"url,attr"`
+	Title string `xml:",chardata"`
+}
+
+// WriteRSS writes the feed as an RSS 2.0 document.
+func WriteRSS(w io.Writer, f Feed) error {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Self,
+			Description:   f.Title,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Generator:     "ReadKey",
+			Self:          rssSelf{"self", "application/rss+xml", f.Self},
+		},
+	}
+	for _, e := range f.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{false, e.ID},
+			Description: e.Content,
+			Categories:  e.Categories,
+		}
+		if !e.Published.IsZero() {
+			item.PubDate = e.Published.UTC().Format(time.RFC1123Z)
+		}
+		if e.SourceTitle != "" && e.SourceURL != "" {
+			item.Source = &rssSource{e.SourceURL, e.SourceTitle}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
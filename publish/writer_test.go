@@ -0,0 +1,100 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func testFeed() Feed {
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	published := time.Date(2024, 3, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	return Feed{
+		ID:      tagURI("readkey.example.com", created, "published/p1"),
+		Title:   "Starred & saved",
+		Self:    "https://readkey.example.com/published/secret",
+		Updated: published,
+		Entries: []Entry{
+			{
+				ID:          tagURI("readkey.example.com", created, "item/feed:abc"),
+				Title:       "Go <generics> explained",
+				Link:        "https://blog.example.org/generics?a=1&b=2",
+				Content:     "<p>Type parameters & constraints.</p>",
+				Published:   published,
+				Updated:     published,
+				Categories:  []string{"go", "generics"},
+				SourceID:    tagURI("readkey.example.com", created, "source/source:def"),
+				SourceTitle: "Example Blog",
+				SourceURL:   "https://blog.example.org/feed",
+				SourceLink:  "https://blog.example.org/",
+			},
+			{
+				ID:      tagURI("readkey.example.com", created, "item/feed:ghi"),
+				Title:   "Undated",
+				Content: "plain",
+				Updated: created,
+			},
+		},
+	}
+}
+
+func TestWriters(t *testing.T) {
+	for _, tt := range []struct {
+		golden string
+		write  func(io.Writer, Feed) error
+	}{
+		{"feed.atom.golden", WriteAtom},
+		{"feed.rss.golden", WriteRSS},
+	} {
+		var buf bytes.Buffer
+		if err := tt.write(&buf, testFeed()); err != nil {
+			t.Fatalf("%s: %v", tt.golden, err)
+		}
+		checkWellFormed(t, tt.golden, buf.Bytes())
+
+		path := filepath.Join("testdata", tt.golden)
+		if *update {
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v (run with -update to create it)", tt.golden, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: output differs from golden file:\n%s", tt.golden, buf.String())
+		}
+	}
+}
+
+// Writing the same feed twice gives the same document, so that readers see no change.
+func TestWritersStable(t *testing.T) {
+	for _, write := range []func(io.Writer, Feed) error{WriteAtom, WriteRSS} {
+		var a, b bytes.Buffer
+		write(&a, testFeed())
+		write(&b, testFeed())
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			t.Errorf("output differs between writes:\n%s\n%s", a.String(), b.String())
+		}
+	}
+}
+
+func checkWellFormed(t *testing.T, name string, doc []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatalf("%s: malformed XML: %v", name, err)
+		}
+	}
+}
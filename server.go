@@ -1,192 +1,557 @@
 package main
 
 import (
-	_ "crypto/sha512"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
-	"os"
+	"fmt"
+	"log"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/edfward/readkey/auth"
+	"github.com/edfward/readkey/backfill"
+	"github.com/edfward/readkey/compat"
+	"github.com/edfward/readkey/digest"
+	"github.com/edfward/readkey/event"
 	"github.com/edfward/readkey/feeder"
+	"github.com/edfward/readkey/keyword"
 	"github.com/edfward/readkey/libstore"
 	"github.com/edfward/readkey/model/feed"
 	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/publish"
 	"github.com/edfward/readkey/util"
+	"github.com/edfward/readkey/webhook"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2"
 )
 
 var (
 	keywordServerEndPoint = flag.String("keywordServerEndPoint", "4567/keywords", "end point of keyword server")
+	keywordCount          = flag.Int("keywordCount", 5, "number of keywords extracted from a feed item")
+	keywordExtractor      = flag.String("keywordExtractor", "auto", "how keywords are extracted, by the keyword server, local or auto (the server, falling back to local while it's unavailable)")
+	keywordConcurrency    = flag.Int("keywordConcurrency", 4, "number of requests to the keyword server in flight at most")
+	keywordRate           = flag.Float64("keywordRate", 0, "number of requests to the keyword server started per second at most, unlimited if 0")
+	keywordTimeout        = flag.Duration("keywordTimeout", 30*time.Second, "timeout of a request to the keyword server")
+	keywordBatchSize      = flag.Int("keywordBatchSize", 0, "number of feed items sent to the keyword server in one request at most, one by one if 0 or 1")
+	keywordCacheTTL       = flag.Duration("keywordCacheTTL", 7*24*time.Hour, "how long keywords from the keyword server are cached by content, not at all if 0")
+	baseURL               = flag.String("baseURL", "", "URL the server is reached at, e.g. https://readkey.example.com, which published feeds are identified by")
 	redisServer           = flag.String("redisServer", ":6379", "")
+	authProviderName      = flag.String("authProvider", "auth0", "authentication provider, one of auth0, oidc, local or proxy")
+	sessionStoreKind      = flag.String("sessionStore", "cookie", "where sessions are kept, cookie or redis")
+	secureCookie          = flag.Bool("secureCookie", true, "only send the session cookie over HTTPS")
+	webhookWorkers        = flag.Int("webhookWorkers", 2, "number of goroutines posting to webhooks")
+	keywordWorkers        = flag.Int("keywordWorkers", 2, "number of goroutines extracting keywords of stored feed items")
+	adminUsers            = flag.String("admins", "", "comma separated user IDs of administrators, e.g. local|alice")
 	fd                    feeder.Feeder
+	authProvider          auth.Provider
 )
 
 // Parse command line arguments and set up libstore and ReadKey feeder.
-func init() {
+func setup() {
 	flag.Parse()
 	// Init the models and backend redis store.
 	rs := libstore.NewStore(*redisServer)
 	user.Setup(rs)
 	feed.Setup(rs)
-	// Init feeder.
-	fd = feeder.NewFeeder("http://localhost:" + *keywordServerEndPoint)
+	event.Setup(rs)
+	webhook.Setup(rs)
+	webhook.Start(*webhookWorkers)
+	digest.Start()
+	// Init keyword extraction and feeder.
+	// The keyword server address such as "http://localhost:4567/keywords".
+	serverFetcher := keyword.NewKeywordFetcher("http://localhost:"+*keywordServerEndPoint, *keywordCount, keyword.ClientOptions{
+		MaxConcurrent: *keywordConcurrency,
+		RateLimit:     *keywordRate,
+		Timeout:       *keywordTimeout,
+		BatchSize:     *keywordBatchSize,
+	})
+	if *keywordCacheTTL > 0 {
+		serverFetcher = keyword.NewCachingFetcher(rs, serverFetcher, *keywordCacheTTL)
+	}
+	var kwFetcher keyword.Fetcher
+	switch *keywordExtractor {
+	case "server":
+		kwFetcher = serverFetcher
+	case "local":
+		kwFetcher = keyword.NewLocalFetcher(*keywordCount)
+	case "auto":
+		kwFetcher = keyword.NewFallbackFetcher(serverFetcher, keyword.NewLocalFetcher(*keywordCount))
+	default:
+		log.Fatalf("[e] Unknown keyword extractor %q, expecting server, local or auto\n", *keywordExtractor)
+	}
+	backfill.Setup(rs, kwFetcher)
+	backfill.Start(*keywordWorkers)
+	fd = feeder.NewFeeder(kwFetcher)
+	// Init authentication provider.
+	var err error
+	if authProvider, err = auth.New(*authProviderName); err != nil {
+		log.Fatalf("[e] Failed to set up authentication: %v\n", err)
+	}
 }
 
-// Middleware for authentication using Auth0.
-func tokenAuthRequired() gin.HandlerFunc {
+// Middleware for authentication, accepting a personal access token sent as "Authorization: Bearer
+// <token>", the authentication provider's own per-request authentication if any, or the session
+// established through its login. The user ID is then set as "userid" in the context. Otherwise
+// `fail` responds with the status (401 if unauthenticated) and reason, e.g. redirecting pages to
+// the login page.
+func tokenAuthRequired(fail func(c *gin.Context, status int, reason string)) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if sessions.Default(c).Get("userid") == nil {
-			c.Redirect(302, "/login")
-		} else {
+		var userID string
+		if header := c.Request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			var err error
+			if userID, err = user.LookupAPIToken(strings.TrimPrefix(header, "Bearer ")); err == user.ErrNotFound {
+				fail(c, 401, "invalid token")
+				c.Abort()
+				return
+			} else if err != nil {
+				log.Printf("[e] Failed to look up token: %v\n", err)
+				fail(c, 500, "storage error")
+				c.Abort()
+				return
+			}
+			c.Set("tokenAuth", true)
+		} else if ra, ok := authProvider.(auth.RequestAuthenticator); ok {
+			userID, _ = ra.Authenticate(c)
+		}
+		if userID == "" {
+			userID, _ = sessions.Default(c).Get(auth.SessionUserKey).(string)
+		}
+
+		if userID != "" {
+			c.Set("userid", userID)
 			c.Next()
+		} else {
+			fail(c, 401, "authentication required")
+			c.Abort()
 		}
 	}
 }
 
-func main() {
-	r := gin.Default()
-	store := sessions.NewCookieStore([]byte("edfward-secret"))
-	r.Use(sessions.Sessions("readkey-session", store))
-
-	// Login endpoint.
-	r.StaticFile("login", "./web/login.html")
-
-	// Auto0 callbacks. From https://auth0.com/docs/server-platforms/golang#go-web-app-tutorial
-	r.GET("callback", func(c *gin.Context) {
-		domain := os.Getenv("AUTH0_DOMAIN")
-		clientSecret := os.Getenv("AUTH0_CLIENT_SECRET")
-
-		// Instantiating the OAuth2 package to exchange the Code for a Token.
-		conf := &oauth2.Config{
-			ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
-			ClientSecret: clientSecret,
-			RedirectURL:  os.Getenv("AUTH0_CALLBACK_URL"),
-			Scopes:       []string{"openid"},
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://" + domain + "/authorize",
-				TokenURL: "https://" + domain + "/oauth/token",
-			},
+// Whether the user is an administrator, as listed by the "admins" flag.
+func isAdmin(userID string) bool {
+	for _, admin := range strings.Split(*adminUsers, ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == userID {
+			return true
 		}
+	}
+	return false
+}
+
+// Redirect unauthenticated requests of pages to the login page.
+func redirectToLogin(c *gin.Context, status int, reason string) {
+	if status == 401 {
+		c.Redirect(302, "/login")
+	} else {
+		c.String(status, reason)
+	}
+}
 
-		// Getting the Code that we got from Auth0.
-		code := c.Query("code")
+// Respond with the error as JSON, e.g. 401 to unauthenticated API requests.
+func respondJSONError(c *gin.Context, status int, reason string) {
+	c.JSON(status, gin.H{"error": reason})
+}
 
-		// Exchanging the code for a token.
-		token, err := conf.Exchange(oauth2.NoContext, code)
-		if err != nil {
-			c.String(500, err.Error())
+// Respond 500 to a request failing due to a storage error, which is logged.
+func respondStorageError(c *gin.Context, err error) {
+	log.Printf("[e] Storage error handling %s %s: %v\n", c.Request.Method, c.Request.URL.Path, err)
+	c.JSON(500, gin.H{"error": "storage error"})
+}
+
+// Interval of comments sent on an idle event stream, so that proxies don't time it out.
+const eventKeepAlive = 30 * time.Second
+
+// Write the events received by the listener to the client as Server-Sent Events until either side
+// stops.
+func streamEvents(c *gin.Context, listener *event.Listener) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(200)
+	c.Writer.Flush()
+
+	closed := c.Request.Context().Done()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(c.Writer, "event: item\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		case <-closed:
 			return
 		}
+		c.Writer.Flush()
+	}
+}
 
-		// Write to session.
-		session := sessions.Default(c)
-		idToken := token.Extra("id_token").(string)
-		// From Auth0's Documentation -> Backend/API -> Go.
-		parsedToken, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
-			decoded, _ := base64.URLEncoding.DecodeString(clientSecret)
-			return decoded, nil
-		})
-		if err == nil && parsedToken.Valid {
-			// Set the value in session, which looks like 'github|123456'.
-			session.Set("userid", parsedToken.Claims["sub"].(string))
-		} else {
-			c.String(500, "parsing id token failed")
-			return
+// Subscription together with the state of its feed source.
+type subscriptionView struct {
+	user.Subscription
+	// Whether the source has been failing to be fetched for a long time.
+	Dead        bool  `json:"dead"`
+	UnreadCount int64 `json:"unreadCount"`
+}
+
+func getSubscriptionViews(username string, subs []user.Subscription) ([]subscriptionView, error) {
+	srcIDs := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		srcIDs = append(srcIDs, sub.SourceID)
+	}
+	health, err := feed.GetSourcesHealth(srcIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]subscriptionView, 0, len(subs))
+	for _, sub := range subs {
+		cnt, err := user.GetUnreadFeedCount(username, sub.SourceID)
+		if err != nil {
+			return nil, err
 		}
-		if err := session.Save(); err != nil {
-			c.String(500, err.Error())
-			return
+		views = append(views, subscriptionView{
+			Subscription: sub,
+			Dead:         health[sub.SourceID].Dead,
+			UnreadCount:  cnt,
+		})
+	}
+	return views, nil
+}
+
+// Get the view of a single subscription, return user.ErrNotFound if not subscribed.
+func getSubscriptionView(username, srcID string) (subscriptionView, error) {
+	sub, err := user.GetFeedSubscription(username, srcID)
+	if err != nil {
+		return subscriptionView{}, err
+	}
+	views, err := getSubscriptionViews(username, []user.Subscription{sub})
+	if err != nil {
+		return subscriptionView{}, err
+	}
+	return views[0], nil
+}
+
+// Error subscribing to an invalid feed.
+type invalidFeedError struct {
+	err error
+}
+
+func (e invalidFeedError) Error() string {
+	return e.err.Error()
+}
+
+// Subscribe the user to the feed at the URL, return the subscribed feed source. Return
+// user.ErrExists if already subscribed, or invalidFeedError if the URL isn't a valid feed.
+func subscribe(username, url string) (feed.Source, error) {
+	src, err := fd.GetFeedSource(url)
+	if err != nil {
+		return feed.Source{}, invalidFeedError{err}
+	}
+	// Also inits unread items for current user.
+	if err := user.Subscribe(username, src); err != nil {
+		return feed.Source{}, err
+	}
+	return src, nil
+}
+
+// Changes to a subscription, where absent fields are left untouched.
+type subscriptionPatch struct {
+	// Display title, empty to restore the source's own title.
+	Title       *string `json:"title"`
+	Notify      *bool   `json:"notify"`
+	FullContent *bool   `json:"fullContent"`
+	// One of "newest" or "oldest", or empty for the default.
+	DefaultSort *string `json:"defaultSort"`
+	HideInRiver *bool   `json:"hideInRiver"`
+	Summaries   *bool   `json:"summaries"`
+}
+
+// Return an error if the changes are invalid.
+func (patch subscriptionPatch) validate() error {
+	if patch.DefaultSort != nil {
+		switch *patch.DefaultSort {
+		case "", user.SortNewest, user.SortOldest:
+		default:
+			return errors.New("defaultSort must be one of 'newest' or 'oldest'")
 		}
-		c.Redirect(302, "/")
-	})
+	}
+	return nil
+}
 
-	authorized := r.Group("/")
-	// Use auth middleware.
-	authorized.Use(tokenAuthRequired())
+// Apply the validated changes to the subscription.
+func (patch subscriptionPatch) apply(sub *user.Subscription) {
+	if patch.DefaultSort != nil {
+		sub.Settings.DefaultSort = *patch.DefaultSort
+	}
+	if patch.Title != nil {
+		sub.CustomTitle = strings.TrimSpace(*patch.Title)
+	}
+	if patch.Notify != nil {
+		sub.Settings.Notify = *patch.Notify
+	}
+	if patch.FullContent != nil {
+		sub.Settings.FullContent = *patch.FullContent
+	}
+	if patch.HideInRiver != nil {
+		sub.Settings.HideInRiver = *patch.HideInRiver
+	}
+	if patch.Summaries != nil {
+		sub.Settings.Summaries = *patch.Summaries
+	}
+}
+
+// Whether the patch turns summaries of the subscription on.
+func (patch subscriptionPatch) enablesSummaries(sub user.Subscription) bool {
+	return patch.Summaries != nil && *patch.Summaries && !sub.Settings.Summaries
+}
+
+// Queue summarizing the stored items of a source once summaries are asked for, new items are
+// summarized as they come.
+func queueSummaries(srcID string) {
+	if n, err := backfill.EnqueueSummaries(srcID); err != nil {
+		log.Printf("[e] Failed to queue summaries of %s: %v\n", srcID, err)
+	} else if n > 0 {
+		log.Printf("[i] Queued summaries of %d items of %s\n", n, srcID)
+	}
+}
+
+func main() {
+	setup()
+	r := gin.Default()
+	store, err := auth.NewSessionStore(*sessionStoreKind, *redisServer, *secureCookie)
+	if err != nil {
+		log.Fatalf("[e] Failed to set up sessions: %v\n", err)
+	}
+	r.Use(auth.SameSite("Lax"))
+	r.Use(sessions.Sessions(auth.SessionName, store))
+
+	// Login endpoints of the authentication provider.
+	authProvider.Mount(r)
+
+	// APIs for third-party feed reader apps, authenticating by personal access tokens.
+	compat.MountGoogleReader(r)
+	compat.MountFever(r)
+
+	// Published feeds, authenticating by the token in their URL.
+	publish.Mount(r, *baseURL)
+
+	// Versioned JSON API.
+	mountAPIv1(r)
+
+	pages := r.Group("/")
+	// Use auth middleware, redirecting to login page if unauthenticated.
+	pages.Use(tokenAuthRequired(redirectToLogin))
 	{
 		// Serve static files.
-		authorized.StaticFile("/", "./web/index.html")
-		authorized.StaticFile("/app.js", "./web/app.js")
-		authorized.StaticFile("/style.css", "./web/style.css")
-		authorized.Static("/assets", "./web/assets")
+		pages.StaticFile("/", "./web/index.html")
+		pages.StaticFile("/app.js", "./web/app.js")
+		pages.StaticFile("/style.css", "./web/style.css")
+		pages.Static("/assets", "./web/assets")
 
 		// Logout endporint.
-		authorized.GET("logout", func(c *gin.Context) {
-			session := sessions.Default(c)
-			session.Clear()
-			session.Save()
+		pages.GET("logout", func(c *gin.Context) {
+			if err := auth.Logout(c); err != nil {
+				log.Printf("[e] Failed to end session: %v\n", err)
+			}
 			c.String(200, "You Have Successfully Logged Out.")
 		})
+	}
+
+	authorized := r.Group("/")
+	// Use auth middleware, responding 401 if unauthenticated.
+	authorized.Use(tokenAuthRequired(respondJSONError))
+	{
+		// List personal access tokens, if successful return the list of format
+		// { tokens: [{ id, name, created }] }.
+		authorized.GET("token", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			tokens, err := user.GetAPITokens(username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			c.JSON(200, gin.H{"tokens": tokens})
+		})
+
+		// Create a personal access token, if successful return the token of format
+		// { id, name, created, feverLogin, token }. The token itself is only shown this once. If a
+		// `fever` username is given, Fever API clients can log in with it and the token as password.
+		authorized.POST("token", func(c *gin.Context) {
+			if _, viaToken := c.Get("tokenAuth"); viaToken {
+				c.JSON(403, gin.H{"error": "tokens can only be created from a login session"})
+				return
+			}
+			username := c.MustGet("userid").(string)
+			name, feverLogin := strings.TrimSpace(c.PostForm("name")), strings.TrimSpace(c.PostForm("fever"))
+			token, t, err := user.CreateAPIToken(username, name, feverLogin)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			c.JSON(201, gin.H{"id": t.TokenID, "name": t.Name, "created": t.Created, "feverLogin": t.FeverLogin, "token": token})
+		})
+
+		// Revoke a personal access token.
+		authorized.DELETE("token/*id", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			// Off-by-one to ignore the first '/'.
+			tokenID := c.Param("id")[1:]
+			if tokenID == "" {
+				c.Writer.WriteHeader(404)
+				return
+			}
+			if err := user.RevokeAPIToken(username, tokenID); err == user.ErrNotFound {
+				c.Writer.WriteHeader(404)
+			} else if err != nil {
+				respondStorageError(c, err)
+			} else {
+				c.Writer.WriteHeader(200)
+			}
+		})
 
 		// Get the list of subscribed feed sources, if successful return the list of format
-		// { subscriptions: [{ id, title }] }.
+		// { subscriptions: [{ id, title, url, customTitle, settings, dead, unreadCount }] } where
+		// `dead` flags sources failing to be fetched for a long time.
 		authorized.GET("subscription", func(c *gin.Context) {
-			username := sessions.Default(c).Get("userid").(string)
-			subs := user.GetFeedSubscriptions(username)
-			c.JSON(200, gin.H{"subscriptions": subs})
+			username := c.MustGet("userid").(string)
+			subs, err := user.GetFeedSubscriptions(username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			views, err := getSubscriptionViews(username, subs)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			c.JSON(200, gin.H{"subscriptions": views})
 		})
 
 		// Add a subscription, if successful return the subscribed feed source of format
 		// { id, title }.
 		authorized.POST("subscription", func(c *gin.Context) {
-			c.Writer.WriteHeader(400)
-			username := sessions.Default(c).Get("userid").(string)
-			if subURL := c.PostForm("url"); subURL != "" {
-				src, err := fd.GetFeedSource(subURL)
-				if err != nil {
-					c.JSON(400, gin.H{"error": err.Error()})
-					return
-				}
-				if ok := user.AppendFeedSubscription(username, src); ok {
-					feed.AddSourceSubscriber(src.SourceID, username)
-					// Init unread items for current user.
-					user.InitUserUnreadQueue(username, src.SourceID)
-					c.JSON(201, src)
-				} else {
-					// Duplicates or error.
-					c.JSON(409, gin.H{"error": "duplicate subscription or storage error"})
-				}
+			username := c.MustGet("userid").(string)
+			subURL := c.PostForm("url")
+			if subURL == "" {
+				c.JSON(400, gin.H{"error": "missing url"})
+				return
+			}
+			src, err := subscribe(username, subURL)
+			if _, invalid := err.(invalidFeedError); invalid {
+				c.JSON(400, gin.H{"error": err.Error()})
+			} else if err == user.ErrExists {
+				c.JSON(409, gin.H{"error": "duplicate subscription"})
+			} else if err != nil {
+				respondStorageError(c, err)
+			} else {
+				c.JSON(201, src)
 			}
 		})
 
 		// Retrieve a specific subscription / feed source, if successful return the list of format
-		// { feeds: [{ id, keywords, pubDate, title }] }.
+		// { feeds: [{ id, keywords, pubDate, title, lang }] }, only of the language `lang` if given.
 		authorized.GET("subscription/*id", func(c *gin.Context) {
-			c.Writer.WriteHeader(400)
-			username := sessions.Default(c).Get("userid").(string)
+			username := c.MustGet("userid").(string)
 			// TODO: Get unread parameter from request.
 			// unreadOnly := true
-			if subID := c.Param("id"); subID != "/" {
-				// Off-by-one to ignore the first '/'.
-				subID = util.Escape(subID[1:])
-				unreadIds := user.GetUnreadFeedIds(username, subID)
-				entries := feed.GetItemEntriesFromSource(subID, unreadIds)
-				c.JSON(200, gin.H{"feeds": entries})
+			subID := c.Param("id")
+			if subID == "/" {
+				c.Writer.WriteHeader(400)
+				return
+			}
+			// Off-by-one to ignore the first '/'.
+			subID = util.Escape(subID[1:])
+			unreadIds, err := user.GetUnreadFeedIds(username, subID)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			entries, err := feed.GetItemEntriesFromSource(subID, unreadIds)
+			if err != nil {
+				respondStorageError(c, err)
+				return
 			}
+			if sub, err := user.GetFeedSubscription(username, subID); err != nil && err != user.ErrNotFound {
+				respondStorageError(c, err)
+				return
+			} else if !sub.Settings.Summaries {
+				hideSummaries(entries)
+			}
+			c.JSON(200, gin.H{"feeds": filterLang(entries, c.Query("lang"))})
 		})
 
 		// Unsubscribe a feed source.
 		authorized.DELETE("subscription/*id", func(c *gin.Context) {
-			c.Writer.WriteHeader(404)
-			username := sessions.Default(c).Get("userid").(string)
-			if subID := c.Param("id"); subID != "/" {
-				// Off-by-one to ignore the first '/'.
-				subID = util.Escape(subID[1:])
-				if success := user.RemoveFeedSubscription(username, subID); success {
-					c.Writer.WriteHeader(200)
-				} else {
-					c.Writer.WriteHeader(404)
-				}
+			username := c.MustGet("userid").(string)
+			subID := c.Param("id")
+			if subID == "/" {
+				c.Writer.WriteHeader(404)
+				return
+			}
+			// Off-by-one to ignore the first '/'.
+			subID = util.Escape(subID[1:])
+			if err := user.RemoveFeedSubscription(username, subID); err == user.ErrNotFound {
+				c.Writer.WriteHeader(404)
+			} else if err != nil {
+				respondStorageError(c, err)
+			} else {
+				c.Writer.WriteHeader(200)
+			}
+		})
+
+		// Override the display title or change preferences of a subscription. Accepts a JSON body
+		// { title, notify, fullContent, defaultSort, hideInRiver, summaries } where absent fields
+		// are left untouched and an empty title restores the source's own title. If successful
+		// return the updated subscription.
+		authorized.PATCH("subscription/*id", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			subID := c.Param("id")
+			if subID == "/" {
+				c.JSON(400, gin.H{"error": "missing subscription id"})
+				return
+			}
+			// Off-by-one to ignore the first '/'.
+			subID = util.Escape(subID[1:])
+
+			var patch subscriptionPatch
+			if err := c.BindJSON(&patch); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			} else if err := patch.validate(); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+
+			var enabling bool
+			sub, err := user.UpdateFeedSubscription(username, subID, func(sub *user.Subscription) {
+				enabling = patch.enablesSummaries(*sub)
+				patch.apply(sub)
+			})
+			if err == user.ErrNotFound {
+				c.JSON(404, gin.H{"error": "subscription not found"})
+				return
+			} else if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			if enabling {
+				queueSummaries(subID)
 			}
+			c.JSON(200, sub)
 		})
 
 		// Mark a feed item as read.
 		authorized.PUT("subscription/*id", func(c *gin.Context) {
-			username := sessions.Default(c).Get("userid").(string)
+			username := c.MustGet("userid").(string)
 			var form struct {
 				ItemID  string `form:"itemId"`
 				Read    bool   `form:"read"`
@@ -195,12 +560,17 @@ func main() {
 
 			if c.Bind(&form) == nil {
 				srcID := util.Escape(c.Param("id")[1:])
+				var err error
 				if form.MarkAll {
-					user.RemoveAllUnreadFeedItem(username, srcID)
+					err = user.RemoveAllUnreadFeedItem(username, srcID)
 				} else {
 					// Mark specific feed item.
 					feedID := util.Escape(form.ItemID)
-					user.RemoveUnreadFeedItemID(username, srcID, feedID)
+					err = user.RemoveUnreadFeedItemID(username, srcID, feedID)
+				}
+				if err != nil {
+					respondStorageError(c, err)
+					return
 				}
 				c.Writer.WriteHeader(204)
 			}
@@ -208,23 +578,72 @@ func main() {
 
 		// Fetch number of unread entries.
 		authorized.GET("unreadcount/*id", func(c *gin.Context) {
-			c.Writer.WriteHeader(400)
-			username := sessions.Default(c).Get("userid").(string)
-			if subID := c.Param("id"); subID != "/" {
-				// Off-by-one to ignore the first '/'.
-				subID = util.Escape(subID[1:])
-				cnt := user.GetUnreadFeedCount(username, subID)
-				c.String(200, strconv.FormatInt(cnt, 10))
+			username := c.MustGet("userid").(string)
+			subID := c.Param("id")
+			if subID == "/" {
+				c.Writer.WriteHeader(400)
+				return
+			}
+			// Off-by-one to ignore the first '/'.
+			subID = util.Escape(subID[1:])
+			cnt, err := user.GetUnreadFeedCount(username, subID)
+			if err != nil {
+				respondStorageError(c, err)
+				return
 			}
+			c.String(200, strconv.FormatInt(cnt, 10))
+		})
+
+		// Stream events of subscribed feed sources as Server-Sent Events, currently only "item" events
+		// of new feed items of format { sourceId, entry: { id, title, keywords, pubDate }, unreadCount }.
+		authorized.GET("events", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			listener, err := event.Listen(username)
+			if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			defer listener.Close()
+			streamEvents(c, listener)
+		})
+
+		// Retrieve the fetching health of a subscribed feed source, if successful return the
+		// status of format { lastSuccess, lastFailure, lastError, consecutiveFailures, httpStatus,
+		// parseError, dead }.
+		authorized.GET("source/:id/status", func(c *gin.Context) {
+			username := c.MustGet("userid").(string)
+			srcID := util.Escape(c.Param("id"))
+			if _, err := user.GetFeedSubscription(username, srcID); err == user.ErrNotFound {
+				c.JSON(404, gin.H{"error": "subscription not found"})
+				return
+			} else if err != nil {
+				respondStorageError(c, err)
+				return
+			}
+			// Nothing is recorded if the source is yet to be fetched.
+			health, err := feed.GetSourceHealth(srcID)
+			if err != nil && err != feed.ErrNotFound {
+				respondStorageError(c, err)
+				return
+			}
+			c.JSON(200, health)
 		})
 
 		// Retrieve the specific feed of the format { link, content } if successful.
 		authorized.GET("feed/*id", func(c *gin.Context) {
-			c.Writer.WriteHeader(400)
-			if feedID := c.Param("id"); feedID != "/" {
-				// Off-by-one to ignore the first '/'.
-				feedID = util.Escape(feedID[1:])
-				item := feed.GetItem(feedID)
+			feedID := c.Param("id")
+			if feedID == "/" {
+				c.Writer.WriteHeader(400)
+				return
+			}
+			// Off-by-one to ignore the first '/'.
+			feedID = util.Escape(feedID[1:])
+			item, err := feed.GetItem(feedID)
+			if err == feed.ErrNotFound {
+				c.JSON(404, gin.H{"error": "feed not found"})
+			} else if err != nil {
+				respondStorageError(c, err)
+			} else {
 				c.JSON(200, item)
 			}
 		})
@@ -0,0 +1,186 @@
+// Package summary summarizes texts by extraction: sentences are scored by how frequent their words
+// are in the whole text, leaving out stopwords, and the top ones are kept in their original order.
+package summary
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/edfward/readkey/keyword"
+)
+
+// Sentences of a summary, more for texts of at least `longText` sentences.
+const (
+	shortSize = 2
+	longSize  = 3
+	longText  = 8
+)
+
+// Words a sentence has at least to be picked, and at most before it's left out as likely not
+// prose, e.g. a list run together by sanitizing. In scripts without word spacing, such as Chinese or
+// Japanese, two runes count as a word.
+const (
+	minWords = 4
+	maxWords = 60
+)
+
+// Boost of the first sentence's score, which tends to introduce the text.
+const leadBoost = 1.25
+
+type sentence struct {
+	text  string
+	words []string
+	score float64
+}
+
+// Summarize returns a summary of 2 or 3 sentences of a plain text in the language, e.g. "en", or
+// English if unknown. Texts with no more sentences are returned whole, and those without any
+// sentence fit for a summary get an empty one.
+func Summarize(text, lang string) string {
+	var sentences []*sentence
+	for _, s := range splitSentences(text) {
+		words, length := splitWords(s)
+		if length >= minWords && length <= maxWords {
+			sentences = append(sentences, &sentence{text: s, words: words})
+		}
+	}
+	size := shortSize
+	if len(sentences) >= longText {
+		size = longSize
+	}
+	if len(sentences) <= size {
+		return join(sentences)
+	}
+
+	stopwords := keyword.StopwordsOf(lang)
+	freq := make(map[string]int)
+	top := 0
+	for _, s := range sentences {
+		for _, w := range s.words {
+			if !stopwords[w] {
+				freq[w]++
+				if freq[w] > top {
+					top = freq[w]
+				}
+			}
+		}
+	}
+	for i, s := range sentences {
+		content := 0
+		for _, w := range s.words {
+			if !stopwords[w] {
+				s.score += float64(freq[w]) / float64(top)
+				content++
+			}
+		}
+		// Dampen the advantage of long sentences without favoring fragments.
+		if content > 0 {
+			s.score /= math.Sqrt(float64(content))
+		}
+		if i == 0 {
+			s.score *= leadBoost
+		}
+	}
+
+	ranked := make([]*sentence, len(sentences))
+	copy(ranked, sentences)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	picked := make(map[*sentence]bool, size)
+	for _, s := range ranked[:size] {
+		picked[s] = true
+	}
+	var res []*sentence
+	for _, s := range sentences {
+		if picked[s] {
+			res = append(res, s)
+		}
+	}
+	return join(res)
+}
+
+// Join the sentences with spaces, except after those ending in a script without word spacing.
+func join(sentences []*sentence) string {
+	var b strings.Builder
+	for i, s := range sentences {
+		if i > 0 {
+			if last, _ := utf8.DecodeLastRuneInString(b.String()); !unspacedEnd(last) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+// Whether a sentence ending in the rune is followed without a space, e.g. after "。".
+func unspacedEnd(r rune) bool {
+	return r == '。' || r == '！' || r == '？' || keyword.IsUnspaced(r)
+}
+
+// Split the text into sentences after terminal punctuation followed by a space, and at line breaks.
+func splitSentences(text string) []string {
+	var res []string
+	runes := []rune(text)
+	start := 0
+	flush := func(end int) {
+		if s := strings.Join(strings.Fields(string(runes[start:end])), " "); s != "" {
+			res = append(res, s)
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			flush(i + 1)
+		case r == '。' || r == '！' || r == '？':
+			flush(i + 1)
+		case (r == '.' || r == '!' || r == '?' || r == '…') && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])):
+			flush(i + 1)
+		}
+	}
+	flush(len(runes))
+	return res
+}
+
+// Words of the sentence in lower case, and its length in words. Runs of scripts without word
+// spacing are split into overlapping pairs of runes instead, which stand in for their words when
+// scoring.
+func splitWords(s string) (words []string, length int) {
+	var word, run []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			length++
+			word = word[:0]
+		}
+	}
+	flushRun := func() {
+		if len(run) == 1 {
+			words = append(words, string(run))
+		}
+		for i := 0; i+2 <= len(run); i++ {
+			words = append(words, string(run[i:i+2]))
+		}
+		length += (len(run) + 1) / 2
+		run = run[:0]
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case keyword.IsUnspaced(r):
+			flushWord()
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\'' || r == '-':
+			flushRun()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushRun()
+		}
+	}
+	flushWord()
+	flushRun()
+	return
+}
@@ -0,0 +1,69 @@
+package summary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	text := `The city council approved a new housing plan on Tuesday. The weather was mild all week.
+		The housing plan adds two thousand homes near the river. Critics say the housing plan costs too
+		much for the city. A local bakery celebrated its tenth anniversary.`
+	got := Summarize(text, "en")
+	if !strings.HasPrefix(got, "The city council approved") || strings.Count(got, ". ") != 1 {
+		t.Errorf("got %q, want 2 sentences led by the first one", got)
+	}
+	if strings.Contains(got, "weather") || strings.Contains(got, "bakery") {
+		t.Errorf("got %q, want the sentences off the topic left out", got)
+	}
+}
+
+func TestSummarizeShortText(t *testing.T) {
+	text := "Go 1.22 is out. It changes how loop variables are scoped."
+	if got := Summarize(text, "en"); got != text {
+		t.Errorf("got %q, want the whole text", got)
+	}
+	if got := Summarize("Hi. Bye.", "en"); got != "" {
+		t.Errorf("got %q, want none for sentences too short", got)
+	}
+}
+
+func TestSummarizeUnspacedScripts(t *testing.T) {
+	tests := []struct {
+		lang string
+		text string
+	}{
+		{"zh", "北京市政府今天公布了新的住房计划。今天的天气非常晴朗。新的住房计划将在河边建造两千套住房。" +
+			"批评者认为新的住房计划成本太高。一家本地面包店庆祝了十周年。"},
+		{"ja", "東京都は今日、新しい住宅計画を発表しました。今日はとても良い天気でした。" +
+			"新しい住宅計画では川の近くに二千戸の住宅を建てます。批判的な人々は住宅計画の費用が高すぎると言っています。"},
+	}
+	for _, tt := range tests {
+		got := Summarize(tt.text, tt.lang)
+		if got == "" || len(got) >= len(tt.text) {
+			t.Errorf("%s: got %q, want a summary shorter than the text", tt.lang, got)
+		}
+		if strings.Contains(got, " ") {
+			t.Errorf("%s: got %q, want sentences joined without spaces", tt.lang, got)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		s      string
+		words  []string
+		length int
+	}{
+		{"It's a well-known fact.", []string{"it's", "a", "well-known", "fact"}, 4},
+		{"住房计划", []string{"住房", "房计", "计划"}, 2},
+		{"新iPhone发布", []string{"新", "iphone", "发布"}, 3},
+	}
+	for _, tt := range tests {
+		words, length := splitWords(tt.s)
+		if !reflect.DeepEqual(words, tt.words) || length != tt.length {
+			t.Errorf("splitWords(%q) = %q, %d, want %q, %d", tt.s, words, length, tt.words, tt.length)
+		}
+	}
+}
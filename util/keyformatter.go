@@ -24,6 +24,21 @@ func FormatFeedSourceKey(rawFeedSrcID string) string {
 	return Escape("src:" + rawFeedSrcID)
 }
 
+// FormatUserTokensKey returns key for mapping from a user to his personal access tokens.
+func FormatUserTokensKey(user string) string {
+	return Escape("tokens:" + user)
+}
+
+// FormatAPITokenKey returns key for mapping from the hash of a personal access token to its user.
+func FormatAPITokenKey(tokenHash string) string {
+	return Escape("token:" + tokenHash)
+}
+
+// FormatAccountsKey returns key for mapping from a local account's username to its password hash.
+func FormatAccountsKey() string {
+	return "accounts"
+}
+
 // FormatLatestFeedsKey returns key for mapping from a feed source to its latest feed IDs.
 func FormatLatestFeedsKey(feedSrcID string) string {
 	return Escape("latest:" + feedSrcID)
@@ -34,11 +49,106 @@ func FormatSubscriberKey(feedSrcID string) string {
 	return Escape("subscriber:" + feedSrcID)
 }
 
-// FormatListeningKey returns key to retrieve currently listening feed sources.
+// FormatSourceHealthKey returns key for mapping from a feed source to its fetching health.
+func FormatSourceHealthKey(feedSrcID string) string {
+	return Escape("health:" + feedSrcID)
+}
+
+// FormatListeningKey returns key for mapping from a feed source ID to a currently listening feed source.
 func FormatListeningKey() string {
 	return "listening"
 }
 
+// FormatSourceAliasKey returns key for mapping from an alias URL to a feed source ID.
+func FormatSourceAliasKey() string {
+	return "alias"
+}
+
+// FormatItemRefsKey returns key for mapping from a feed item to its numeric reference.
+func FormatItemRefsKey() string {
+	return "itemrefs"
+}
+
+// FormatSourceRefsKey returns key for mapping from a feed source to its numeric reference.
+func FormatSourceRefsKey() string {
+	return "srcrefs"
+}
+
+// FormatRefLookupKey returns key for mapping from numeric references back to what `refsKey` maps from.
+func FormatRefLookupKey(refsKey string) string {
+	return refsKey + ":lookup"
+}
+
+// FormatRefCounterKey returns key of the last numeric reference assigned in `refsKey`.
+func FormatRefCounterKey(refsKey string) string {
+	return refsKey + ":seq"
+}
+
+// FormatUserStarredKey returns key for mapping from a user's starred feed IDs to their feed sources.
+func FormatUserStarredKey(user string) string {
+	return Escape("starred:" + user)
+}
+
+// FormatFeverKey returns key for mapping from a Fever API key to its user.
+func FormatFeverKey(apiKey string) string {
+	return Escape("fever:" + apiKey)
+}
+
+// FormatUserEventsKey returns the pub/sub channel of events to a user's connected clients.
+func FormatUserEventsKey(user string) string {
+	return Escape("events:" + user)
+}
+
+// FormatUserWebhooksKey returns key for mapping from a user to his webhooks.
+func FormatUserWebhooksKey(user string) string {
+	return Escape("webhooks:" + user)
+}
+
+// FormatWebhookLogKey returns key for mapping from a user + a webhook to its latest deliveries.
+func FormatWebhookLogKey(user, webhookID string) string {
+	return Escape("webhooklog:" + user + ":" + webhookID)
+}
+
+// FormatWebhookQueueKey returns key of the queue of pending webhook deliveries.
+func FormatWebhookQueueKey() string {
+	return "webhookqueue"
+}
+
+// FormatDigestPrefsKey returns key for mapping from a user to his email digest preferences.
+func FormatDigestPrefsKey() string {
+	return "digest"
+}
+
+// FormatDigestSentKey returns key for mapping from a user to when his last email digest was sent.
+func FormatDigestSentKey() string {
+	return "digest:sent"
+}
+
+// FormatUserPublishedKey returns key for mapping from a user to the feeds he publishes.
+func FormatUserPublishedKey(user string) string {
+	return Escape("published:" + user)
+}
+
+// FormatPublishedTokenKey returns key for mapping from the hash of a published feed's token to the feed.
+func FormatPublishedTokenKey(tokenHash string) string {
+	return Escape("publishedtoken:" + tokenHash)
+}
+
+// FormatKeywordQueueKey returns key of the queue of feed items whose keywords are to be extracted.
+func FormatKeywordQueueKey() string {
+	return "keywordqueue"
+}
+
+// FormatKeywordCacheKey returns key of the cached keywords of a text, by the hash of the text and its language.
+func FormatKeywordCacheKey(hash string) string {
+	return "keywordcache:" + hash
+}
+
+// FormatItemEntriesVersionKey returns key of the format version stored feed item entries are migrated to.
+func FormatItemEntriesVersionKey() string {
+	return "version:entries"
+}
+
 // Escape simply used `QueryEscape` from `url` library.
 func Escape(s string) string {
 	return url.QueryEscape(s)
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Query parameters only used for tracking, which are dropped when normalizing URLs. Parameters
+// prefixed with "utm_" are dropped as well.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+// NormalizeURL canonicalizes a feed URL so that different spellings of the same feed map to the
+// same key: scheme and host are lowercased, default ports, fragments, tracking query parameters and
+// trailing slashes are dropped, and the remaining query parameters are sorted. Credentials are
+// kept, as private feeds behind them differ by user. HTTP and HTTPS are treated alike since sites
+// commonly serve a feed over both, so the scheme is left out for them. The result is meant as a key
+// rather than for fetching. Unparsable URLs are returned as-is.
+func NormalizeURL(rawURL string) string {
+	s := strings.TrimSpace(rawURL)
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "feed" {
+		scheme = "http"
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Host), ".")
+	if h, port, err := net.SplitHostPort(host); err == nil &&
+		(port == "80" && scheme == "http" || port == "443" && scheme == "https") {
+		host = h
+		if strings.Contains(h, ":") {
+			// IPv6 literal.
+			host = "[" + h + "]"
+		}
+	}
+
+	query := u.Query()
+	for k := range query {
+		if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+			query.Del(k)
+		}
+	}
+
+	if u.User != nil {
+		host = u.User.String() + "@" + host
+	}
+	res := host + strings.TrimRight(u.EscapedPath(), "/")
+	if encoded := query.Encode(); encoded != "" {
+		res += "?" + encoded
+	}
+	if scheme != "http" && scheme != "https" {
+		res = scheme + "://" + res
+	}
+	return res
+}
@@ -0,0 +1,43 @@
+package util
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"lowercases host", "http://Example.COM/Feed", "example.com/Feed"},
+		{"folds http and https", "https://example.com/feed", "example.com/feed"},
+		{"defaults scheme", "example.com/feed", "example.com/feed"},
+		{"folds feed scheme", "feed://example.com/feed", "example.com/feed"},
+		{"keeps other schemes", "ftp://example.com/feed", "ftp://example.com/feed"},
+		{"drops default http port", "http://example.com:80/feed", "example.com/feed"},
+		{"drops default https port", "https://example.com:443/feed", "example.com/feed"},
+		{"keeps other ports", "https://example.com:8443/feed", "example.com:8443/feed"},
+		{"keeps port of other scheme", "http://example.com:443/feed", "example.com:443/feed"},
+		{"drops ipv6 default port", "http://[::1]:80/feed", "[::1]/feed"},
+		{"drops trailing dot", "http://example.com./feed", "example.com/feed"},
+		{"drops trailing slash", "http://example.com/feed/", "example.com/feed"},
+		{"drops fragment", "http://example.com/feed#top", "example.com/feed"},
+		{"drops utm params", "http://example.com/feed?utm_source=x&UTM_Medium=y", "example.com/feed"},
+		{"drops click ids", "http://example.com/feed?fbclid=1&gclid=2&id=3", "example.com/feed?id=3"},
+		{"sorts query", "http://example.com/feed?b=2&a=1", "example.com/feed?a=1&b=2"},
+		{"trims spaces", "  http://example.com/feed  ", "example.com/feed"},
+		{"keeps userinfo", "https://user:pw@example.com/feed", "user:pw@example.com/feed"},
+		{"keeps username", "https://user@Example.com/feed", "user@example.com/feed"},
+		{"returns unparsable as-is", "http://%zz", "http://%zz"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeURL(tt.in); got != tt.want {
+			t.Errorf("%s: NormalizeURL(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURLKeepsUsersApart(t *testing.T) {
+	a := NormalizeURL("https://user:pw@host/feed")
+	b := NormalizeURL("https://other:pw@host/feed")
+	if a == b {
+		t.Errorf("feeds of different users normalized alike: %q", a)
+	}
+}
@@ -0,0 +1,240 @@
+// Package webhook posts new feed items to the webhooks users register, e.g. to pipe feeds into chat
+// or ticket systems. Deliveries go through a durable queue in Redis and are retried with backoff
+// while the receiver fails. Payloads are JSON, signed with HMAC-SHA256 of the webhook's secret in
+// the "X-ReadKey-Signature" header as "sha256=<hex digest>".
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/edfward/readkey/libstore"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+	"github.com/edfward/readkey/util"
+)
+
+// Events, i.e. kinds of payloads.
+const (
+	EventItem = "item"
+	EventPing = "ping"
+)
+
+// Attempts of a delivery before giving up, and the delay before the first retry which quadruples
+// after each one, i.e. retrying for about 6 hours.
+const (
+	maxAttempts = 6
+	retryDelay  = time.Minute
+)
+
+var (
+	queue  *libstore.Queue
+	client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Setup must be called before other functions to configure the Redis store.
+func Setup(store libstore.RedisStrore) {
+	queue = libstore.NewQueue(store, util.FormatWebhookQueueKey())
+}
+
+// ItemPayload is posted for a new feed item.
+type ItemPayload struct {
+	Event  string         `json:"event"`
+	Source feed.Source    `json:"source"`
+	Entry  feed.ItemEntry `json:"entry"`
+	// Link of the feed item itself.
+	Link string `json:"link"`
+}
+
+// PingPayload is posted by test deliveries.
+type PingPayload struct {
+	Event   string       `json:"event"`
+	Webhook user.Webhook `json:"webhook"`
+}
+
+// Queued delivery of a payload to a webhook.
+type job struct {
+	DeliveryID string          `json:"id"`
+	User       string          `json:"user"`
+	WebhookID  string          `json:"webhookId"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempt    int             `json:"attempt"`
+}
+
+// DeliverItem queues deliveries of a new feed item of the source to the user's matching webhooks.
+func DeliverItem(username string, src feed.Source, entry feed.ItemEntry, link string) error {
+	webhooks, err := user.GetWebhooks(username)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, w := range webhooks {
+		if !w.Matches(src.SourceID, entry) {
+			continue
+		}
+		if payload == nil {
+			payload, _ = json.Marshal(ItemPayload{EventItem, src, entry, link})
+		}
+		j := job{
+			DeliveryID: newDeliveryID(),
+			User:       username,
+			WebhookID:  w.WebhookID,
+			Event:      EventItem,
+			Payload:    payload,
+			Attempt:    1,
+		}
+		packet, _ := json.Marshal(j)
+		if err := queue.Push(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Test posts a ping to a webhook of the user right away, without retrying, and logs it like other
+// deliveries. Return user.ErrNotFound if no such webhook.
+func Test(username, webhookID string) (user.WebhookDelivery, error) {
+	w, secret, err := user.GetWebhook(username, webhookID)
+	if err != nil {
+		return user.WebhookDelivery{}, err
+	}
+	payload, _ := json.Marshal(PingPayload{EventPing, w})
+	d := post(w.URL, secret, newDeliveryID(), EventPing, payload)
+	d.Attempt = 1
+	return d, user.AppendWebhookDelivery(username, webhookID, d)
+}
+
+// Start recovers deliveries interrupted by the last stop, then delivers queued payloads with
+// `workers` goroutines in the background.
+func Start(workers int) {
+	if n, err := queue.Requeue(); err != nil {
+		log.Printf("[e] Failed to requeue interrupted webhook deliveries: %v\n", err)
+	} else if n > 0 {
+		log.Printf("[i] Requeued %d interrupted webhook deliveries\n", n)
+	}
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			if _, err := queue.PromoteDue(); err != nil {
+				log.Printf("[e] Failed to queue webhook deliveries due for retry: %v\n", err)
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go work()
+	}
+}
+
+func work() {
+	for {
+		packet, err := queue.Pop(5 * time.Second)
+		if err != nil {
+			log.Printf("[e] Failed to take webhook delivery from queue: %v\n", err)
+			time.Sleep(retryDelay)
+			continue
+		} else if packet == nil {
+			continue
+		}
+		var j job
+		if err := json.Unmarshal(packet, &j); err != nil {
+			log.Printf("[e] Dropped malformed webhook delivery: %v\n", err)
+		} else if retry := deliver(j); retry != nil {
+			next, _ := json.Marshal(retry)
+			if err := queue.RetryAt(packet, next, time.Now().Add(backoff(j.Attempt))); err != nil {
+				log.Printf("[e] Failed to schedule retry of webhook delivery %s: %v\n", j.DeliveryID, err)
+			}
+			continue
+		}
+		if err := queue.Ack(packet); err != nil {
+			log.Printf("[e] Failed to remove webhook delivery from queue: %v\n", err)
+		}
+	}
+}
+
+// Post the payload of a queued delivery and log it, return the next attempt if it is to be retried.
+func deliver(j job) *job {
+	w, secret, err := user.GetWebhook(j.User, j.WebhookID)
+	if err == user.ErrNotFound {
+		// Removed since queued.
+		return nil
+	} else if err != nil {
+		log.Printf("[e] Failed to get webhook of delivery %s: %v\n", j.DeliveryID, err)
+		// Not counted as an attempt.
+		return &j
+	}
+
+	d := post(w.URL, secret, j.DeliveryID, j.Event, j.Payload)
+	d.Attempt = j.Attempt
+	d.Retrying = d.Error != "" && retryable(d.Status) && j.Attempt < maxAttempts
+	if err := user.AppendWebhookDelivery(j.User, j.WebhookID, d); err != nil {
+		log.Printf("[e] Failed to log webhook delivery %s: %v\n", j.DeliveryID, err)
+	}
+	if !d.Retrying {
+		return nil
+	}
+	j.Attempt++
+	return &j
+}
+
+// Post a signed payload, return the outcome as a delivery without the attempt number.
+func post(url, secret, deliveryID, event string, payload []byte) user.WebhookDelivery {
+	d := user.WebhookDelivery{DeliveryID: deliveryID, Event: event, Time: time.Now().Unix()}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ReadKey-Webhook")
+	req.Header.Set("X-ReadKey-Event", event)
+	req.Header.Set("X-ReadKey-Delivery", deliveryID)
+	req.Header.Set("X-ReadKey-Signature", "sha256="+Sign(secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	resp.Body.Close()
+	d.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		d.Error = "unexpected HTTP status " + resp.Status
+	}
+	return d
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of a payload with the webhook's secret, as sent in the
+// "X-ReadKey-Signature" header.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Whether a failed delivery may succeed later: no response, or the receiver being unavailable or
+// limiting the rate. Other client errors won't go away by retrying.
+func retryable(status int) bool {
+	return status == 0 || status == 408 || status == 429 || status >= 500
+}
+
+// Delay before retrying after a failed attempt.
+func backoff(attempt int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 4
+	}
+	return delay
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edfward/readkey/keyword"
+	"github.com/edfward/readkey/libstore/storetest"
+	"github.com/edfward/readkey/model/feed"
+	"github.com/edfward/readkey/model/user"
+)
+
+// Request received by the stub receiver.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// Set up a store and a receiver answering `status`, with a webhook of alice posting to it.
+func setup(t *testing.T, status int) (secret string, w user.Webhook, reqs <-chan received) {
+	user.Setup(storetest.New())
+	ch := make(chan received, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ch <- received{r.Header, body}
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	secret, w, err := user.CreateWebhook("alice", user.Webhook{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, w, ch
+}
+
+func TestDeliverSigns(t *testing.T) {
+	secret, w, reqs := setup(t, http.StatusOK)
+	payload := []byte(`{"event":"item"}`)
+
+	if retry := deliver(job{DeliveryID: "d1", User: "alice", WebhookID: w.WebhookID, Event: EventItem, Payload: payload, Attempt: 1}); retry != nil {
+		t.Errorf("delivery retried as %+v", *retry)
+	}
+	r := <-reqs
+	if string(r.body) != string(payload) {
+		t.Errorf("posted %s, want %s", r.body, payload)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(r.body)
+	if got, want := r.header.Get("X-ReadKey-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	if got := r.header.Get("X-ReadKey-Event"); got != EventItem {
+		t.Errorf("event %q, want %q", got, EventItem)
+	}
+	if got := r.header.Get("X-ReadKey-Delivery"); got != "d1" {
+		t.Errorf("delivery ID %q, want d1", got)
+	}
+
+	log, err := user.GetWebhookDeliveries("alice", w.WebhookID)
+	if err != nil || len(log) != 1 {
+		t.Fatalf("got %v, %v, want a delivery logged", log, err)
+	}
+	if d := log[0]; d.DeliveryID != "d1" || d.Status != http.StatusOK || d.Error != "" || d.Retrying {
+		t.Errorf("logged %+v, want a success", d)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	_, w, reqs := setup(t, http.StatusServiceUnavailable)
+	j := job{DeliveryID: "d1", User: "alice", WebhookID: w.WebhookID, Event: EventItem, Payload: []byte(`{}`), Attempt: 1}
+
+	for attempt := 1; attempt < maxAttempts; attempt++ {
+		retry := deliver(j)
+		<-reqs
+		if retry == nil || retry.Attempt != attempt+1 || retry.DeliveryID != "d1" {
+			t.Fatalf("attempt %d retried as %+v, want attempt %d", attempt, retry, attempt+1)
+		}
+		j = *retry
+	}
+	if retry := deliver(j); retry != nil {
+		t.Errorf("last attempt retried as %+v", *retry)
+	}
+	<-reqs
+
+	log, err := user.GetWebhookDeliveries("alice", w.WebhookID)
+	if err != nil || len(log) != maxAttempts {
+		t.Fatalf("got %d deliveries logged, %v, want %d", len(log), err, maxAttempts)
+	}
+	// Latest first.
+	if d := log[0]; d.Attempt != maxAttempts || d.Retrying || d.Status != http.StatusServiceUnavailable {
+		t.Errorf("logged last attempt as %+v", d)
+	}
+	if d := log[1]; d.Attempt != maxAttempts-1 || !d.Retrying {
+		t.Errorf("logged attempt before last as %+v", d)
+	}
+}
+
+func TestDeliverGivesUpOnClientError(t *testing.T) {
+	_, w, reqs := setup(t, http.StatusNotFound)
+	if retry := deliver(job{DeliveryID: "d1", User: "alice", WebhookID: w.WebhookID, Event: EventItem, Payload: []byte(`{}`), Attempt: 1}); retry != nil {
+		t.Errorf("delivery retried as %+v", *retry)
+	}
+	<-reqs
+}
+
+func TestDeliverRemoved(t *testing.T) {
+	_, w, reqs := setup(t, http.StatusOK)
+	if err := user.RemoveWebhook("alice", w.WebhookID); err != nil {
+		t.Fatal(err)
+	}
+	if retry := deliver(job{DeliveryID: "d1", User: "alice", WebhookID: w.WebhookID, Event: EventItem, Payload: []byte(`{}`), Attempt: 1}); retry != nil {
+		t.Errorf("delivery to removed webhook retried as %+v", *retry)
+	}
+	select {
+	case <-reqs:
+		t.Error("posted to removed webhook")
+	default:
+	}
+}
+
+func TestPing(t *testing.T) {
+	secret, w, reqs := setup(t, http.StatusOK)
+
+	d, err := Test("alice", w.WebhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Event != EventPing || d.Attempt != 1 || d.Status != http.StatusOK || d.Error != "" {
+		t.Errorf("got %+v, want a successful ping", d)
+	}
+	r := <-reqs
+	if got := r.header.Get("X-ReadKey-Event"); got != EventPing {
+		t.Errorf("event %q, want %q", got, EventPing)
+	}
+	if got, want := r.header.Get("X-ReadKey-Signature"), "sha256="+Sign(secret, r.body); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	var p PingPayload
+	if err := json.Unmarshal(r.body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != EventPing || p.Webhook != w {
+		t.Errorf("posted %+v, want a ping of %+v", p, w)
+	}
+	if log, err := user.GetWebhookDeliveries("alice", w.WebhookID); err != nil || len(log) != 1 || log[0].DeliveryID != d.DeliveryID {
+		t.Errorf("logged %v, %v, want the ping", log, err)
+	}
+
+	if _, err := Test("alice", "missing"); err != user.ErrNotFound {
+		t.Errorf("got %v pinging a missing webhook, want ErrNotFound", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// From RFC 4231, test case 2.
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	if want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for status, want := range map[int]bool{
+		0:   true,
+		200: false,
+		400: false,
+		404: false,
+		408: true,
+		410: false,
+		429: true,
+		500: true,
+		503: true,
+	} {
+		if got := retryable(status); got != want {
+			t.Errorf("retryable(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{time.Minute, 4 * time.Minute, 16 * time.Minute, 64 * time.Minute, 256 * time.Minute}
+	var total time.Duration
+	for i, d := range want {
+		if got := backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, d)
+		}
+		total += d
+	}
+	// Retrying for about 6 hours in all.
+	if total < 5*time.Hour || total > 7*time.Hour {
+		t.Errorf("retrying for %v", total)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	entry := feed.ItemEntry{Title: "Release notes", Keywords: []keyword.Keyword{{Text: "Golang"}}}
+	for _, c := range []struct {
+		w     user.Webhook
+		srcID string
+		want  bool
+	}{
+		{user.Webhook{}, "src:a", true},
+		{user.Webhook{SourceID: "src:a"}, "src:a", true},
+		{user.Webhook{SourceID: "src:a"}, "src:b", false},
+		{user.Webhook{Keyword: "RELEASE"}, "src:a", true},
+		{user.Webhook{Keyword: "golang"}, "src:a", true},
+		{user.Webhook{Keyword: "rust"}, "src:a", false},
+		{user.Webhook{SourceID: "src:a", Keyword: "golang"}, "src:b", false},
+	} {
+		if got := c.w.Matches(c.srcID, entry); got != c.want {
+			t.Errorf("%+v matches item of %s: %v, want %v", c.w, c.srcID, got, c.want)
+		}
+	}
+}